@@ -2,22 +2,24 @@ const API_BASE_URL = 'http://localhost:9095';
 
 // File service for handling API calls
 export const fileService = {
-  // Upload a file
+  // Upload a file as multipart/form-data
   uploadFile: async (id, filename, file) => {
     try {
-      const response = await fetch(`${API_BASE_URL}/images/${id}/${filename}`, {
+      const formData = new FormData();
+      formData.append('file', file, filename);
+
+      // the browser sets the multipart Content-Type with its boundary for us
+      const response = await fetch(`${API_BASE_URL}/images/${id}`, {
         method: 'POST',
-        body: file,
-        headers: {
-          'Content-Type': 'application/octet-stream',
-        },
+        body: formData,
       });
       
       if (!response.ok) {
         throw new Error(`Upload failed: ${response.statusText}`);
       }
       
-      return { success: true, message: 'File uploaded successfully' };
+      const saved = await response.json();
+      return { success: true, message: 'File uploaded successfully', files: saved };
     } catch (error) {
       console.error('Upload error:', error);
       throw error;
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"shared/accesslog"
+	"shared/conf"
+	"shared/cors"
+	"shared/trace"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// EnvPrefix starts the name of every environment variable the file-server reads
+const EnvPrefix = "FILESERVER_"
+
+// Config holds the file-server settings. Each setting can come from a YAML file,
+// named by -config or FILESERVER_CONFIG, using the yaml keys, from an environment
+// variable FILESERVER_ followed by the env names of the setting and its parents,
+// e.g. FILESERVER_SERVER_READ_TIMEOUT, or from a flag named like the environment
+// variable without the prefix, e.g. -server-read-timeout. Flags override the
+// environment which overrides the file which overrides the defaults. Secrets can
+// be read from a file named by the variable with _FILE appended, e.g.
+// FILESERVER_SIGNING_KEY_FILE.
+//
+// Settings tagged reload:"true" are applied without a restart when the process
+// receives SIGHUP or the config file changes, see reload.go
+type Config struct {
+	BindAddress string `yaml:"bind_address" env:"BIND_ADDRESS" default:":9095" usage:"address the server listens on"`
+	LogLevel    string `yaml:"log_level" env:"LOG_LEVEL" reload:"true" default:"debug" usage:"trace, debug, info, warn or error"`
+	BasePath    string `yaml:"base_path" env:"BASE_PATH" default:"./imagestore" usage:"directory files are stored in"`
+	// local stores files as they are named, cas stores content once by hash
+	StorageMode string `yaml:"storage_mode" env:"STORAGE_MODE" default:"local" usage:"local or cas"`
+	// largest file which can be uploaded, the same limit applies to resumable uploads
+	MaxFileSize conf.ByteSize `yaml:"max_file_size" env:"MAX_FILE_SIZE" default:"5MB" usage:"largest file which can be uploaded, e.g. 5MB"`
+	// largest archive which can be uploaded in one request
+	MaxArchiveSize conf.ByteSize `yaml:"max_archive_size" env:"MAX_ARCHIVE_SIZE" default:"100MB" usage:"largest zip or tar upload"`
+	// content types accepted for upload, detected from the file contents, * accepts anything
+	AllowedTypes []string `yaml:"allowed_types" env:"ALLOWED_TYPES" default:"image/png,image/jpeg,image/webp,image/gif" usage:"comma separated content types accepted for upload, * for any"`
+	// largest width or height that can be requested when resizing images
+	MaxImageDimension int `yaml:"max_image_dimension" env:"MAX_IMAGE_DIMENSION" default:"2048" usage:"largest width or height of a resized image"`
+	// resumable uploads not finished within this time are removed
+	UploadExpiry time.Duration `yaml:"upload_expiry" env:"UPLOAD_EXPIRY" default:"24h" usage:"time before unfinished resumable uploads are removed"`
+	// deleted files are kept in the trash this long, zero deletes immediately
+	TrashRetention time.Duration `yaml:"trash_retention" env:"TRASH_RETENTION" default:"168h" usage:"time deleted files are kept in the trash, 0 deletes immediately"`
+	// prior versions kept when a file is overwritten, zero disables versioning
+	MaxVersions int `yaml:"max_versions" env:"MAX_VERSIONS" default:"0" usage:"prior versions kept per file, 0 disables versioning"`
+
+	Server    ServerConfig     `yaml:"server" env:"SERVER"`
+	CORS      cors.Config      `yaml:"cors" env:"CORS"`
+	Signing   SigningConfig    `yaml:"signing" env:"SIGNING"`
+	Quotas    QuotaConfig      `yaml:"quotas" env:"QUOTA"`
+	Webhooks  WebhookConfig    `yaml:"webhooks" env:"WEBHOOK"`
+	Products  ProductsConfig   `yaml:"products" env:"PRODUCT_API"`
+	Tracing   trace.Config     `yaml:"tracing" env:"TRACING"`
+	AccessLog accesslog.Config `yaml:"access_log" env:"ACCESS_LOG"`
+
+	// arguments left after the flags, e.g. the scrub command
+	args []string
+	// the config file read, empty when there was none
+	file string
+}
+
+// ServerConfig holds the HTTP server timeouts
+type ServerConfig struct {
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" default:"5s" usage:"max time to read a request"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" default:"10s" usage:"max time to write a response"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT" default:"120s" usage:"max time a keep-alive connection waits for the next request"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"30s" usage:"time running requests are given to finish on shutdown"`
+}
+
+// SigningConfig holds the signed URL settings
+type SigningConfig struct {
+	// secret for signing URLs, signed URLs are disabled when empty
+	Key string `yaml:"key" env:"KEY" reload:"true" secret:"true" usage:"secret for signed URLs, empty disables them"`
+	// bearer token required to mint signed URLs
+	AdminKey string `yaml:"admin_key" env:"ADMIN_KEY" reload:"true" secret:"true" usage:"bearer token required by POST /sign"`
+	// refuse requests for images, uploads and trash items which are not signed
+	Required bool `yaml:"required" env:"REQUIRED" reload:"true" usage:"refuse requests for images, uploads and trash items without a valid signature"`
+}
+
+// QuotaConfig holds the storage quotas per id and for the whole store, zero is unlimited
+type QuotaConfig struct {
+	IDBytes    conf.ByteSize `yaml:"id_bytes" env:"ID_BYTES" reload:"true" usage:"max bytes per id"`
+	IDFiles    int64         `yaml:"id_files" env:"ID_FILES" reload:"true" usage:"max files per id"`
+	TotalBytes conf.ByteSize `yaml:"total_bytes" env:"TOTAL_BYTES" reload:"true" usage:"max bytes across all ids"`
+	TotalFiles int64         `yaml:"total_files" env:"TOTAL_FILES" reload:"true" usage:"max files across all ids"`
+}
+
+// WebhookConfig holds the URLs sent file change events
+type WebhookConfig struct {
+	URLs   []string `yaml:"urls" env:"URLS" usage:"comma separated URLs sent file change events"`
+	Secret string   `yaml:"secret" env:"SECRET" reload:"true" secret:"true" usage:"HMAC secret signing webhook requests"`
+}
+
+// ProductsConfig holds where to check products exist before accepting uploads
+type ProductsConfig struct {
+	// product-api base URL, empty disables the check
+	URL     string        `yaml:"url" env:"URL" usage:"product-api checked before accepting uploads, empty disables the check"`
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" default:"5s" usage:"max time to wait for product-api"`
+}
+
+// Load reads the configuration from the defaults, the config file, the environment
+// and the command line args, see Config, and validates it. Usage is written to
+// os.Stderr for -h and flag errors
+func Load(args []string) (*Config, error) {
+	return load(args, os.Stderr)
+}
+
+func load(args []string, usage io.Writer) (*Config, error) {
+	cfg := &Config{}
+
+	src, err := conf.Load(cfg, conf.Options{
+		Name:      "file-server",
+		EnvPrefix: EnvPrefix,
+		FileEnv:   EnvPrefix + "CONFIG",
+		Args:      args,
+		Usage:     usage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.args = src.Args
+	cfg.file = src.File
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Args returns the command line arguments left after the flags
+func (c *Config) Args() []string {
+	return c.args
+}
+
+// File returns the path of the config file read, empty when there was none
+func (c *Config) File() string {
+	return c.file
+}
+
+// Validate checks every setting and returns all the problems found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, a...)...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.BindAddress)
+	check(err == nil, "bind_address", "must be host:port or :port, got %q", c.BindAddress)
+	check(hclog.LevelFromString(c.LogLevel) != hclog.NoLevel, "log_level", "must be trace, debug, info, warn or error, got %q", c.LogLevel)
+	check(c.BasePath != "", "base_path", "must be set")
+	check(c.StorageMode == "local" || c.StorageMode == "cas", "storage_mode", "must be local or cas, got %q", c.StorageMode)
+	check(c.MaxFileSize > 0, "max_file_size", "must be greater than 0")
+	check(c.MaxArchiveSize > 0, "max_archive_size", "must be greater than 0")
+	check(c.MaxImageDimension > 0, "max_image_dimension", "must be greater than 0")
+	check(c.UploadExpiry > 0, "upload_expiry", "must be greater than 0")
+	check(c.TrashRetention >= 0, "trash_retention", "must not be negative")
+	check(c.MaxVersions >= 0, "max_versions", "must not be negative")
+
+	check(c.Server.ReadTimeout > 0, "server.read_timeout", "must be greater than 0")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout", "must be greater than 0")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout", "must be greater than 0")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be greater than 0")
+
+	if err := c.CORS.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.AccessLog.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(!c.Signing.Required || c.Signing.Key != "", "signing.required", "needs signing.key to be set")
+
+	check(c.Quotas.IDBytes >= 0, "quotas.id_bytes", "must not be negative")
+	check(c.Quotas.IDFiles >= 0, "quotas.id_files", "must not be negative")
+	check(c.Quotas.TotalBytes >= 0, "quotas.total_bytes", "must not be negative")
+	check(c.Quotas.TotalFiles >= 0, "quotas.total_files", "must not be negative")
+
+	check(len(c.Webhooks.URLs) == 0 || c.Webhooks.Secret != "", "webhooks.secret", "must be set when webhooks.urls is")
+	for _, u := range c.Webhooks.URLs {
+		check(validURL(u), "webhooks.urls", "%q is not an http or https URL", u)
+	}
+
+	check(c.Products.URL == "" || validURL(c.Products.URL), "products.url", "%q is not an http or https URL", c.Products.URL)
+	check(c.Products.Timeout > 0, "products.timeout", "must be greater than 0")
+
+	return errors.Join(errs...)
+}
+
+// validURL reports whether s is an absolute http or https URL
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, contents string) string {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(p, []byte(contents), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.BindAddress != ":9095" || cfg.MaxFileSize != 5<<20 || cfg.Server.WriteTimeout != 10*time.Second {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if len(cfg.AllowedTypes) != 4 || len(cfg.CORS.AllowedOrigins) != 1 {
+		t.Fatalf("unexpected default lists %v %v", cfg.AllowedTypes, cfg.CORS.AllowedOrigins)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	p := writeFile(t, `
+log_level: info
+max_file_size: 1MB
+max_image_dimension: 1024
+server:
+  read_timeout: 20s
+quotas:
+  id_bytes: 4096
+`)
+
+	// file < env < flags
+	t.Setenv(EnvPrefix+"CONFIG", p)
+	t.Setenv(EnvPrefix+"MAX_IMAGE_DIMENSION", "512")
+	t.Setenv(EnvPrefix+"SERVER_READ_TIMEOUT", "30s")
+
+	cfg, err := load([]string{"-server-read-timeout", "40s", "-signing-required", "-signing-key", "k", "scrub"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.LogLevel != "info" || cfg.MaxFileSize != 1<<20 || cfg.Quotas.IDBytes != 4096 {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.MaxImageDimension != 512 {
+		t.Errorf("env should override the file, got %d", cfg.MaxImageDimension)
+	}
+	if cfg.Server.ReadTimeout != 40*time.Second {
+		t.Errorf("flag should override env, got %s", cfg.Server.ReadTimeout)
+	}
+	if !cfg.Signing.Required {
+		t.Errorf("boolean flag without a value should be true")
+	}
+	if args := cfg.Args(); len(args) != 1 || args[0] != "scrub" {
+		t.Errorf("expected the scrub argument, got %v", args)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{
+			name: "unknown key in file",
+			file: "max_file_sise: 1MB\n",
+			want: []string{"max_file_sise"},
+		},
+		{
+			name: "bad env value",
+			env:  map[string]string{EnvPrefix + "UPLOAD_EXPIRY": "tomorrow"},
+			want: []string{EnvPrefix + "UPLOAD_EXPIRY", "invalid duration"},
+		},
+		{
+			name: "bad flag value",
+			args: []string{"-max-file-size", "lots"},
+			want: []string{"-max-file-size", "invalid size"},
+		},
+		{
+			name: "every validation problem is reported",
+			args: []string{"-storage-mode", "s3", "-log-level", "loud", "-signing-required", "-webhook-urls", "ftp://x", "-cors-allow-credentials"},
+			want: []string{"storage_mode", "log_level", "signing.required", "webhooks.secret", "webhooks.urls", "cors.allowed_origins"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file != "" {
+				t.Setenv(EnvPrefix+"CONFIG", writeFile(t, tt.file))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := load(tt.args, io.Discard)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("expected %q in error %q", w, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"file-server/files"
+
+	"golang.org/x/xerrors"
+)
+
+// types of event published when files change
+const (
+	FileUploaded    = "file.uploaded"
+	FileOverwritten = "file.overwritten"
+	FileDeleted     = "file.deleted"
+)
+
+// Event describes a change to a stored file
+type Event struct {
+	ID   string         `json:"id"`
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	File files.FileInfo `json:"file"`
+}
+
+// Publisher is anything events can be sent to
+type Publisher interface {
+	Publish(e Event)
+}
+
+// New creates an event of type typ for the file fi
+func New(typ string, fi files.FileInfo) (Event, error) {
+	id, err := newID()
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{ID: id, Type: typ, Time: time.Now().UTC(), File: fi}, nil
+}
+
+// newID returns a random id for an event or delivery
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", xerrors.Errorf("Unable to create id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// eventsDir is the hidden directory under the base path holding the outbox
+const eventsDir = ".events"
+
+// Delivery is one event waiting to be sent to one webhook URL
+type Delivery struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Outbox persists deliveries on disk so webhooks are still sent after a restart.
+// Pending deliveries are {basePath}/.events/outbox/{id}.json, deliveries which ran
+// out of attempts are moved to {basePath}/.events/failed/ for inspection
+type Outbox struct {
+	pending string
+	failed  string
+}
+
+// NewOutbox creates the outbox under basePath
+func NewOutbox(basePath string) (*Outbox, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, eventsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{pending: filepath.Join(p, "outbox"), failed: filepath.Join(p, "failed")}
+	for _, d := range []string{o.pending, o.failed} {
+		err = os.MkdirAll(d, os.ModePerm)
+		if err != nil {
+			return nil, xerrors.Errorf("Unable to create outbox directory: %w", err)
+		}
+	}
+
+	return o, nil
+}
+
+// Put writes or replaces a pending delivery
+func (o *Outbox) Put(d *Delivery) error {
+	return o.write(o.pending, d)
+}
+
+// Pending returns every pending delivery, oldest first
+func (o *Outbox) Pending() ([]Delivery, error) {
+	entries, err := os.ReadDir(o.pending)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read outbox: %w", err)
+	}
+
+	pending := []Delivery{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(o.pending, e.Name()))
+		if err != nil {
+			continue
+		}
+
+		d := Delivery{}
+		if json.Unmarshal(b, &d) != nil {
+			continue
+		}
+
+		pending = append(pending, d)
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Event.Time.Before(pending[j].Event.Time) })
+
+	return pending, nil
+}
+
+// Due returns the pending deliveries whose next attempt is at or before now, oldest first
+func (o *Outbox) Due(now time.Time) ([]Delivery, error) {
+	pending, err := o.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	due := []Delivery{}
+	for _, d := range pending {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+
+	return due, nil
+}
+
+// Done removes a delivery which was sent
+func (o *Outbox) Done(id string) error {
+	err := os.Remove(filepath.Join(o.pending, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("Unable to remove delivery: %w", err)
+	}
+	return nil
+}
+
+// Fail moves a delivery which will not be retried out of the outbox
+func (o *Outbox) Fail(d *Delivery) error {
+	err := o.write(o.failed, d)
+	if err != nil {
+		return err
+	}
+
+	return o.Done(d.ID)
+}
+
+// write stores d in dir, writing to a temporary file first so a crash never
+// leaves half a delivery behind
+func (o *Outbox) write(dir string, d *Delivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return xerrors.Errorf("Unable to encode delivery: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".delivery-*")
+	if err != nil {
+		return xerrors.Errorf("Unable to write delivery: %w", err)
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, d.ID+".json"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return xerrors.Errorf("Unable to write delivery: %w", err)
+	}
+
+	return nil
+}
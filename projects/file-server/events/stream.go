@@ -0,0 +1,72 @@
+package events
+
+import "sync"
+
+// subscriberBuffer is how many events a slow subscriber can fall behind
+// before it starts missing them
+const subscriberBuffer = 64
+
+// Stream fans events out to live subscribers such as Server-Sent Events clients.
+// Events are not stored, a subscriber only sees what is published while it is
+// subscribed and a subscriber which can't keep up misses events rather than
+// holding up the publisher
+type Stream struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// NewStream creates an empty Stream
+func NewStream() *Stream {
+	return &Stream{subs: map[chan Event]struct{}{}}
+}
+
+// Publish sends e to every subscriber
+func (s *Stream) Publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published events and a function
+// which must be called to unsubscribe. The channel is closed when the Stream is
+func (s *Stream) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	s.mu.Lock()
+	if s.closed {
+		close(ch)
+	} else {
+		s.subs[ch] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, ch)
+			s.mu.Unlock()
+		})
+	}
+}
+
+// Close closes the channel of every subscriber, and of those subscribing later,
+// so long-lived subscribers such as event stream requests end when the server
+// shuts down
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for ch := range s.subs {
+		close(ch)
+		delete(s.subs, ch)
+	}
+}
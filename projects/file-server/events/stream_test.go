@@ -0,0 +1,25 @@
+package events
+
+import "testing"
+
+func TestStreamClose(t *testing.T) {
+	s := NewStream()
+	ch, cancel := s.Subscribe()
+	defer cancel()
+
+	s.Publish(Event{ID: "1"})
+	s.Close()
+	s.Publish(Event{ID: "2"})
+
+	if e, ok := <-ch; !ok || e.ID != "1" {
+		t.Fatalf("expected the event published before Close, got %+v", e)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+
+	late, _ := s.Subscribe()
+	if _, ok := <-late; ok {
+		t.Fatal("expected a subscription after Close to be closed")
+	}
+}
@@ -0,0 +1,255 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/xerrors"
+)
+
+// headers sent with every webhook request
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Webhooks delivers events to a fixed list of URLs. Each event becomes one
+// delivery per URL in the Outbox, a delivery is retried with exponential backoff
+// until the receiver answers with a 2xx status or the attempts run out.
+// Receivers are sent to concurrently, each one's deliveries in order: a failed
+// delivery holds back the later ones to its receiver until it is sent or given up.
+//
+// Requests are signed so receivers can check they came from us, the
+// X-Webhook-Signature header is "sha256=" followed by the hex HMAC-SHA256 of
+// the X-Webhook-Timestamp header, a "." and the request body
+type Webhooks struct {
+	urls   []string
+	secret atomic.Pointer[[]byte]
+	outbox *Outbox
+	client *http.Client
+	log    hclog.Logger
+
+	maxAttempts  int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	maxReceivers int // receivers sent to at the same time
+
+	wake chan struct{}
+}
+
+// NewWebhooks creates a dispatcher sending events to urls signed with secret
+func NewWebhooks(urls []string, secret []byte, o *Outbox, l hclog.Logger) *Webhooks {
+	w := &Webhooks{
+		urls:         urls,
+		outbox:       o,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		log:          l,
+		maxAttempts:  10,
+		baseDelay:    time.Second,
+		maxDelay:     time.Hour,
+		maxReceivers: 8,
+		wake:         make(chan struct{}, 1),
+	}
+	w.SetSecret(secret)
+
+	return w
+}
+
+// SetSecret replaces the secret requests are signed with, deliveries already
+// queued are signed with the new secret when they are sent
+func (w *Webhooks) SetSecret(secret []byte) {
+	w.secret.Store(&secret)
+}
+
+// Publish queues e for every webhook URL, it returns once the deliveries are
+// persisted and does not wait for them to be sent
+func (w *Webhooks) Publish(e Event) {
+	for _, u := range w.urls {
+		id, err := newID()
+		if err == nil {
+			err = w.outbox.Put(&Delivery{ID: id, URL: u, Event: e, NextAttempt: e.Time})
+		}
+		if err != nil {
+			w.log.Error("Unable to queue webhook", "url", u, "event", e.ID, "error", err)
+		}
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is cancelled, it checks the outbox
+// whenever an event is published and otherwise every second for retries
+func (w *Webhooks) Run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		w.Flush(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// Flush sends the due deliveries and returns how many were sent. Each receiver
+// has its own queue worked through in order, stopping at the first delivery which
+// isn't due yet or fails, the next flush resumes there. Receivers are sent to
+// concurrently, at most maxReceivers at a time, so a slow or unreachable receiver
+// only holds up its own deliveries
+func (w *Webhooks) Flush(ctx context.Context) int {
+	pending, err := w.outbox.Pending()
+	if err != nil {
+		w.log.Error("Unable to read webhook outbox", "error", err)
+		return 0
+	}
+
+	// group the deliveries by receiver, keeping them oldest first
+	now := time.Now()
+	queues := map[string][]*Delivery{}
+	var urls []string
+	for i := range pending {
+		d := &pending[i]
+		if _, ok := queues[d.URL]; !ok {
+			urls = append(urls, d.URL)
+		}
+		queues[d.URL] = append(queues[d.URL], d)
+	}
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, w.maxReceivers)
+	for _, u := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(queue []*Delivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			for _, d := range queue {
+				if ctx.Err() != nil || d.NextAttempt.After(now) {
+					return
+				}
+				if !w.attempt(ctx, d) {
+					return
+				}
+				sent.Add(1)
+			}
+		}(queues[u])
+	}
+	wg.Wait()
+
+	return int(sent.Load())
+}
+
+// attempt sends one delivery and records the outcome in the outbox,
+// it reports whether the delivery was sent
+func (w *Webhooks) attempt(ctx context.Context, d *Delivery) bool {
+	err := w.deliver(ctx, d)
+	if err == nil {
+		if err := w.outbox.Done(d.ID); err != nil {
+			w.log.Error("Unable to remove delivered webhook", "delivery", d.ID, "error", err)
+		}
+		return true
+	}
+
+	// shutting down isn't the receiver's fault, try again next time
+	if ctx.Err() != nil {
+		return false
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= w.maxAttempts {
+		w.log.Error("Giving up on webhook", "url", d.URL, "event", d.Event.ID, "attempts", d.Attempts, "error", err)
+		err = w.outbox.Fail(d)
+	} else {
+		d.NextAttempt = time.Now().Add(w.backoff(d.Attempts))
+		w.log.Debug("Webhook failed, will retry", "url", d.URL, "event", d.Event.ID, "attempts", d.Attempts, "next", d.NextAttempt, "error", d.LastError)
+		err = w.outbox.Put(d)
+	}
+	if err != nil {
+		w.log.Error("Unable to update webhook outbox", "delivery", d.ID, "error", err)
+	}
+
+	return false
+}
+
+// deliver posts the delivery's event to its URL
+func (w *Webhooks) deliver(ctx context.Context, d *Delivery) error {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return xerrors.Errorf("Unable to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("Unable to create request: %w", err)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event.Type)
+	req.Header.Set(HeaderID, d.Event.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(*w.secret.Load(), ts, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("Unable to send webhook: %w", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return xerrors.Errorf("Webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff returns how long to wait after the given number of failed attempts,
+// doubling each time up to the maximum delay
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	d := w.baseDelay
+	for i := 1; i < attempts && d < w.maxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, w.maxDelay)
+}
+
+// Sign returns the X-Webhook-Signature value for a body sent at timestamp ts
+func Sign(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature received with a webhook, receivers should also
+// reject timestamps too far from their own clock to stop replays
+func Verify(secret []byte, ts string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
@@ -0,0 +1,219 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"file-server/files"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func testEvent(t *testing.T) Event {
+	e, err := New(FileUploaded, files.FileInfo{ID: "1", Filename: "a.png", Path: "1/a.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestWebhooksSignedDelivery(t *testing.T) {
+	secret := []byte("secret")
+	var verified atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified.Store(Verify(secret, r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) &&
+			r.Header.Get(HeaderEvent) == FileUploaded)
+	}))
+	defer srv.Close()
+
+	o, err := NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebhooks([]string{srv.URL}, secret, o, hclog.NewNullLogger())
+
+	w.Publish(testEvent(t))
+	if n := w.Flush(context.Background()); n != 1 {
+		t.Fatalf("expected 1 delivery, got %d", n)
+	}
+	if !verified.Load() {
+		t.Fatal("receiver could not verify the signature")
+	}
+
+	due, _ := o.Due(time.Now().Add(time.Hour))
+	if len(due) != 0 {
+		t.Fatalf("expected empty outbox, got %d", len(due))
+	}
+}
+
+func TestWebhooksRetryWithBackoff(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	o, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebhooks([]string{srv.URL}, []byte("secret"), o, hclog.NewNullLogger())
+	w.baseDelay = 20 * time.Millisecond
+
+	w.Publish(testEvent(t))
+	if n := w.Flush(context.Background()); n != 0 {
+		t.Fatalf("expected the first attempt to fail, got %d sent", n)
+	}
+
+	// not due again until the backoff has passed
+	if n := w.Flush(context.Background()); n != 0 || calls.Load() != 1 {
+		t.Fatalf("expected no retry before backoff, got %d sent %d calls", n, calls.Load())
+	}
+
+	// the outbox survives a restart
+	o, err = NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = NewWebhooks([]string{srv.URL}, []byte("secret"), o, hclog.NewNullLogger())
+	w.baseDelay = 20 * time.Millisecond
+
+	sent := 0
+	for i := 0; i < 20 && sent == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		sent = w.Flush(context.Background())
+	}
+	if sent != 1 || calls.Load() != 3 {
+		t.Fatalf("expected delivery on the third attempt, got %d sent %d calls", sent, calls.Load())
+	}
+}
+
+func TestWebhooksGiveUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	o, err := NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebhooks([]string{srv.URL}, []byte("secret"), o, hclog.NewNullLogger())
+	w.maxAttempts = 1
+
+	w.Publish(testEvent(t))
+	w.Flush(context.Background())
+
+	due, _ := o.Due(time.Now().Add(time.Hour))
+	if len(due) != 0 {
+		t.Fatalf("expected the delivery to leave the outbox, got %d", len(due))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	w := &Webhooks{baseDelay: time.Second, maxDelay: 10 * time.Second}
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 10 * time.Second} {
+		if got := w.backoff(attempts); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestWebhooksSlowReceiverDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+
+	fast := make(chan struct{}, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fast <- struct{}{}
+	}))
+	defer srv.Close()
+
+	o, err := NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebhooks([]string{slow.URL, srv.URL}, []byte("secret"), o, hclog.NewNullLogger())
+	w.Publish(testEvent(t))
+	w.Publish(testEvent(t))
+
+	sent := make(chan int)
+	go func() { sent <- w.Flush(context.Background()) }()
+
+	// both deliveries reach the fast receiver while the slow one still holds its first
+	for i := 0; i < 2; i++ {
+		select {
+		case <-fast:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("expected the fast receiver to be sent to while the slow one is waiting")
+		}
+	}
+	close(release)
+
+	if n := <-sent; n != 4 {
+		t.Fatalf("expected 4 deliveries, got %d", n)
+	}
+}
+
+func TestWebhooksKeepOrderAfterFailure(t *testing.T) {
+	first := testEvent(t)
+	second := testEvent(t)
+	second.Time = first.Time.Add(time.Millisecond)
+
+	var failed atomic.Bool
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderID)
+		if id == first.ID && failed.CompareAndSwap(false, true) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- id
+	}))
+	defer srv.Close()
+
+	o, err := NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebhooks([]string{srv.URL}, []byte("secret"), o, hclog.NewNullLogger())
+	w.baseDelay = 20 * time.Millisecond
+
+	w.Publish(first)
+	w.Publish(second)
+
+	// the second event waits behind the first while it is backing off
+	if n := w.Flush(context.Background()); n != 0 {
+		t.Fatalf("expected nothing sent after the first failed, got %d", n)
+	}
+	if n := w.Flush(context.Background()); n != 0 {
+		t.Fatalf("expected nothing sent before the backoff, got %d", n)
+	}
+
+	sent := 0
+	for i := 0; i < 20 && sent == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		sent = w.Flush(context.Background())
+	}
+	if sent != 2 {
+		t.Fatalf("expected both deliveries sent after the backoff, got %d", sent)
+	}
+	if a, b := <-received, <-received; a != first.ID || b != second.ID {
+		t.Errorf("expected %s then %s, got %s then %s", first.ID, second.ID, a, b)
+	}
+}
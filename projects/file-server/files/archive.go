@@ -0,0 +1,164 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrTooManyEntries is returned when an uploaded archive has more entries than we accept
+var ErrTooManyEntries = xerrors.New("Archive has too many entries")
+
+// ErrInvalidArchive is returned when an uploaded archive can't be read
+var ErrInvalidArchive = xerrors.New("Invalid archive")
+
+// ErrDuplicateEntry is returned when two entries of an uploaded archive would be saved under the same name
+var ErrDuplicateEntry = xerrors.New("Archive has more than one entry with the same name")
+
+// maxArchiveEntries bounds the number of entries read from an uploaded archive
+const maxArchiveEntries = 1000
+
+// WriteZip streams the files from the storage into a zip archive written to w,
+// entries are named by filename so the archive unpacks into a flat directory
+func WriteZip(w io.Writer, s Storage, fs []FileInfo) error {
+	zw := zip.NewWriter(w)
+
+	for _, fi := range fs {
+		f, err := s.Get(fi.Path)
+		if err != nil {
+			return err
+		}
+
+		h := &zip.FileHeader{Name: fi.Filename, Method: zip.Deflate, Modified: fi.ModTime}
+		ew, err := zw.CreateHeader(h)
+		if err == nil {
+			_, err = io.Copy(ew, f)
+		}
+		f.Close()
+		if err != nil {
+			return xerrors.Errorf("Unable to write %s to archive: %w", fi.Path, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+// WriteTarGz streams the files from the storage into a gzipped tar archive written to w
+func WriteTarGz(w io.Writer, s Storage, fs []FileInfo) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, fi := range fs {
+		f, err := s.Get(fi.Path)
+		if err != nil {
+			return err
+		}
+
+		// the header needs the size up front, take it from the open file in case it
+		// changed since the listing
+		st, err := f.Stat()
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{
+				Name:    fi.Filename,
+				Mode:    0o644,
+				Size:    st.Size(),
+				ModTime: st.ModTime(),
+			})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		f.Close()
+		if err != nil {
+			return xerrors.Errorf("Unable to write %s to archive: %w", fi.Path, err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// ReadZip calls fn with the name and contents of every regular file in the zip archive,
+// directories, symlinks and other special entries are skipped
+func ReadZip(r io.ReaderAt, size int64, fn func(name string, contents io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", err, ErrInvalidArchive)
+	}
+	if len(zr.File) > maxArchiveEntries {
+		return ErrTooManyEntries
+	}
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		f, err := zf.Open()
+		if err != nil {
+			return xerrors.Errorf("%s: %s: %w", zf.Name, err, ErrInvalidArchive)
+		}
+		err = fn(zf.Name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadTar calls fn with the name and contents of every regular file in the tar archive,
+// gzipped archives are detected and decompressed. The archive is read as a stream
+func ReadTar(r io.Reader, gzipped bool, fn func(name string, contents io.Reader) error) error {
+	if gzipped {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return readError(err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for n := 0; ; n++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return readError(err)
+		}
+		if n >= maxArchiveEntries {
+			return ErrTooManyEntries
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		err = fn(h.Name, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readError tells an archive which is not valid apart from a body which could
+// not be read, e.g. because it was larger than allowed
+func readError(err error) error {
+	var ce flate.CorruptInputError
+	switch {
+	case xerrors.Is(err, tar.ErrHeader), xerrors.Is(err, io.ErrUnexpectedEOF), xerrors.Is(err, io.EOF),
+		xerrors.Is(err, gzip.ErrHeader), xerrors.Is(err, gzip.ErrChecksum), xerrors.As(err, &ce):
+		return xerrors.Errorf("%s: %w", err, ErrInvalidArchive)
+	}
+	return xerrors.Errorf("Unable to read archive: %w", err)
+}
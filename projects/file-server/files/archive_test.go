@@ -0,0 +1,156 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// newArchiveStorage stores a.png and b.png under id 1
+func newArchiveStorage(t *testing.T) (*LocalStorage, []FileInfo) {
+	t.Helper()
+	l, err := NewLocalStorage(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Save("1/a.png", bytes.NewBufferString("first"))
+	l.Save("1/b.png", bytes.NewBufferString("second"))
+
+	fs, err := l.ListFilesByID("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, fs
+}
+
+// collect returns a callback for ReadZip and ReadTar recording the entries into m
+func collect(m map[string]string) func(string, io.Reader) error {
+	return func(name string, contents io.Reader) error {
+		d, err := io.ReadAll(contents)
+		m[name] = string(d)
+		return err
+	}
+}
+
+func TestZipRoundTrip(t *testing.T) {
+	l, fs := newArchiveStorage(t)
+
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, l, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	err := ReadZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), collect(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["a.png"] != "first" || got["b.png"] != "second" {
+		t.Errorf("unexpected entries %v", got)
+	}
+}
+
+func TestTarGzRoundTrip(t *testing.T) {
+	l, fs := newArchiveStorage(t)
+
+	var buf bytes.Buffer
+	if err := WriteTarGz(&buf, l, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	if err := ReadTar(&buf, true, collect(got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["a.png"] != "first" || got["b.png"] != "second" {
+		t.Errorf("unexpected entries %v", got)
+	}
+}
+
+func TestReadArchivesSkipSpecialEntries(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	zw.Create("photos/")
+	w, _ := zw.Create("photos/a.png")
+	w.Write([]byte("a"))
+	zw.Close()
+
+	got := map[string]string{}
+	if err := ReadZip(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()), collect(got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["photos/a.png"] != "a" {
+		t.Errorf("expected only the file entry, got %v", got)
+	}
+
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	tw.WriteHeader(&tar.Header{Name: "photos/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "link.png", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tw.WriteHeader(&tar.Header{Name: "photos/a.png", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1})
+	tw.Write([]byte("a"))
+	tw.Close()
+
+	got = map[string]string{}
+	if err := ReadTar(&tbuf, false, collect(got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["photos/a.png"] != "a" {
+		t.Errorf("expected only the file entry, got %v", got)
+	}
+}
+
+func TestReadArchivesEntryLimit(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	for i := 0; i <= maxArchiveEntries; i++ {
+		zw.Create("a.png")
+		tw.WriteHeader(&tar.Header{Name: "a.png", Typeflag: tar.TypeReg, Mode: 0o644})
+	}
+	zw.Close()
+	tw.Close()
+
+	n := 0
+	count := func(string, io.Reader) error { n++; return nil }
+
+	err := ReadZip(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()), count)
+	if !xerrors.Is(err, ErrTooManyEntries) || n != 0 {
+		t.Errorf("expected ErrTooManyEntries before reading any entry, got %v after %d", err, n)
+	}
+
+	err = ReadTar(&tbuf, false, count)
+	if !xerrors.Is(err, ErrTooManyEntries) {
+		t.Errorf("expected ErrTooManyEntries, got %v", err)
+	}
+}
+
+func TestReadArchivesInvalid(t *testing.T) {
+	noop := func(string, io.Reader) error { return nil }
+
+	junk := []byte("not an archive")
+	if err := ReadZip(bytes.NewReader(junk), int64(len(junk)), noop); !xerrors.Is(err, ErrInvalidArchive) {
+		t.Errorf("expected ErrInvalidArchive for zip, got %v", err)
+	}
+	if err := ReadTar(bytes.NewReader(junk), true, noop); !xerrors.Is(err, ErrInvalidArchive) {
+		t.Errorf("expected ErrInvalidArchive for tar.gz, got %v", err)
+	}
+
+	// a truncated tar.gz fails part way through
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "a.png", Typeflag: tar.TypeReg, Mode: 0o644, Size: 100})
+	tw.Write(bytes.Repeat([]byte("a"), 100))
+	tw.Close()
+	gw.Close()
+	if err := ReadTar(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), true, noop); err == nil {
+		t.Error("expected an error for a truncated archive")
+	}
+}
@@ -0,0 +1,282 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// casDir is the hidden directory under the base path holding blobs and the name index
+const casDir = ".cas"
+
+// casEntry maps a stored name to the blob holding its content
+type casEntry struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// CASStorage is a content addressed implementation of the Storage interface.
+// File contents are stored once as blobs named by their SHA-256, {id}/{filename}
+// names are kept in an index pointing at the blobs. Saving identical content under
+// many names only stores it once, a blob is removed when its last name is deleted
+type CASStorage struct {
+	maxFileSize int // maximum number of bytes for files
+	basePath    string
+
+	mu    sync.Mutex
+	index map[string]casEntry // name -> blob
+	refs  map[string]int      // blob hash -> number of names using it
+}
+
+// NewCASStorage creates a new content addressed storage with the given base path
+// basePath is the base directory, blobs and the index live in {basePath}/.cas
+// maxSize is the max number of bytes that a file can be
+func NewCASStorage(basePath string, maxSize int) (*CASStorage, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, casDir))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(p, "blobs"), os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create blob directory: %w", err)
+	}
+
+	c := &CASStorage{
+		maxFileSize: maxSize,
+		basePath:    p,
+		index:       map[string]casEntry{},
+		refs:        map[string]int{},
+	}
+
+	// load the existing index, a missing index is a new empty store
+	d, err := os.ReadFile(c.indexPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, xerrors.Errorf("Unable to read index: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(d, &c.index)
+		if err != nil {
+			return nil, xerrors.Errorf("Unable to decode index: %w", err)
+		}
+	}
+
+	// reference counts are derived from the index rather than stored,
+	// so they can never drift from the names that actually exist
+	for _, e := range c.index {
+		c.refs[e.Hash]++
+	}
+
+	return c, nil
+}
+
+// Save the contents of the Writer to the given path
+// the contents are hashed while they are written, if a blob with the same hash
+// already exists the new copy is discarded and the name points at the existing blob
+func (c *CASStorage) Save(path string, contents io.Reader) error {
+	h := sha256.New()
+	tmp, n, err := writeTemp(filepath.Join(c.basePath, "blobs"), contents, c.maxFileSize, h)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// only move the blob into place if we don't have this content yet
+	bp := c.blobPath(hash)
+	_, err = os.Stat(bp)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(bp), os.ModePerm)
+		if err != nil {
+			return xerrors.Errorf("Unable to create blob directory: %w", err)
+		}
+
+		err = os.Rename(tmp, bp)
+		if err != nil {
+			return xerrors.Errorf("Unable to save blob: %w", err)
+		}
+	} else if err != nil {
+		return xerrors.Errorf("Unable to get blob info: %w", err)
+	}
+
+	key := c.key(path)
+	old, exists := c.index[key]
+	c.index[key] = casEntry{Hash: hash, Size: n, ModTime: time.Now().UTC()}
+	c.refs[hash]++
+
+	err = c.writeIndex()
+	if err != nil {
+		// roll back so memory matches what is on disk
+		if exists {
+			c.index[key] = old
+		} else {
+			delete(c.index, key)
+		}
+		c.release(hash)
+		return err
+	}
+
+	// the name used to point at other content, drop our reference to it
+	if exists {
+		c.release(old.Hash)
+	}
+
+	return nil
+}
+
+// Get the file at the given path and return a Reader
+// the calling function is responsible for closing the reader
+func (c *CASStorage) Get(path string) (*os.File, error) {
+	c.mu.Lock()
+	e, ok := c.index[c.key(path)]
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, xerrors.Errorf("Unable to open file: %w", os.ErrNotExist)
+	}
+
+	f, err := os.Open(c.blobPath(e.Hash))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to open file: %w", err)
+	}
+
+	return f, nil
+}
+
+// Exists reports whether the index has an entry for the path
+func (c *CASStorage) Exists(path string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.index[c.key(path)]
+	return ok, nil
+}
+
+// ListFiles returns a list of all files in the storage, including the hash of their content
+func (c *CASStorage) ListFiles() ([]FileInfo, error) {
+	return c.list("")
+}
+
+// ListFilesByID returns the files stored for one id
+func (c *CASStorage) ListFilesByID(id string) ([]FileInfo, error) {
+	if !validID(id) {
+		return nil, ErrInvalidFilename
+	}
+
+	return c.list(id)
+}
+
+// list returns the files in the index, limited to one id when id is not empty
+func (c *CASStorage) list(id string) ([]FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := []FileInfo{}
+	for name, e := range c.index {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 || id != "" && parts[0] != id {
+			continue
+		}
+
+		files = append(files, FileInfo{
+			ID:       parts[0],
+			Filename: filepath.Base(name),
+			Size:     e.Size,
+			Path:     filepath.FromSlash(name),
+			Hash:     e.Hash,
+			ModTime:  e.ModTime,
+		})
+	}
+
+	// map iteration order is random, keep listings stable
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, nil
+}
+
+// DeleteFile deletes a file at the given path
+// the blob is only removed when no other name refers to the same content
+func (c *CASStorage) DeleteFile(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.key(path)
+	e, ok := c.index[key]
+	if !ok {
+		return xerrors.Errorf("File not found: %s", path)
+	}
+
+	delete(c.index, key)
+	err := c.writeIndex()
+	if err != nil {
+		c.index[key] = e
+		return err
+	}
+
+	c.release(e.Hash)
+	return nil
+}
+
+// release drops a reference to a blob and removes the blob when it was the last one
+// must be called with the lock held
+func (c *CASStorage) release(hash string) {
+	c.refs[hash]--
+	if c.refs[hash] > 0 {
+		return
+	}
+
+	delete(c.refs, hash)
+	// a blob we fail to remove only wastes space, it is no longer reachable
+	os.Remove(c.blobPath(hash))
+}
+
+// writeIndex persists the name index, must be called with the lock held
+func (c *CASStorage) writeIndex() error {
+	d, err := json.Marshal(c.index)
+	if err != nil {
+		return xerrors.Errorf("Unable to encode index: %w", err)
+	}
+
+	tmp := c.indexPath() + ".tmp"
+	err = os.WriteFile(tmp, d, 0o644)
+	if err != nil {
+		return xerrors.Errorf("Unable to write index: %w", err)
+	}
+
+	err = os.Rename(tmp, c.indexPath())
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("Unable to write index: %w", err)
+	}
+
+	return nil
+}
+
+// key normalises a relative path into the name used in the index
+func (c *CASStorage) key(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// blobPath returns the absolute path of a blob, blobs are fanned out into
+// directories by the first two characters of their hash to keep directories small
+func (c *CASStorage) blobPath(hash string) string {
+	return filepath.Join(c.basePath, "blobs", hash[:2], hash)
+}
+
+func (c *CASStorage) indexPath() string {
+	return filepath.Join(c.basePath, "index.json")
+}
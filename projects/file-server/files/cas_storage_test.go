@@ -0,0 +1,80 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	filepath.Walk(filepath.Join(dir, casDir, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestCASDeduplicatesAndReferenceCounts(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCASStorage(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"1/a.png", "2/b.png"} {
+		if err := c.Save(p, bytes.NewBufferString("same content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countBlobs(t, dir); n != 1 {
+		t.Fatalf("expected 1 blob for identical content, got %d", n)
+	}
+
+	fs, err := c.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 2 || fs[0].Hash == "" || fs[0].Hash != fs[1].Hash {
+		t.Fatalf("expected 2 files sharing a hash, got %+v", fs)
+	}
+
+	// deleting one name keeps the blob for the other
+	if err := c.DeleteFile("1/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.Get("2/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := io.ReadAll(f)
+	f.Close()
+	if string(d) != "same content" {
+		t.Fatalf("unexpected content %q", d)
+	}
+
+	// overwriting the last name with new content drops the old blob
+	if err := c.Save("2/b.png", bytes.NewBufferString("new content")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, dir); n != 1 {
+		t.Fatalf("expected old blob to be removed, got %d blobs", n)
+	}
+
+	// the index survives a restart
+	c2, err := NewCASStorage(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.DeleteFile("2/b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, dir); n != 0 {
+		t.Fatalf("expected no blobs after deleting every name, got %d", n)
+	}
+}
@@ -0,0 +1,117 @@
+package files
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrChecksumMismatch is returned when uploaded content does not match the digest sent by the client
+var ErrChecksumMismatch = xerrors.New("Checksum mismatch")
+
+// ErrInvalidDigest is returned when a Content-MD5 or Digest header can't be parsed
+var ErrInvalidDigest = xerrors.New("Invalid digest header")
+
+// ParseDigests reads the digests a client sent with an upload from the Content-MD5
+// header and the Digest header (RFC 3230, e.g. "sha-256=base64, md5=base64").
+// The result maps the algorithm name (md5, sha-256) to the expected raw digest,
+// algorithms we don't support are ignored
+func ParseDigests(h http.Header) (map[string][]byte, error) {
+	digests := map[string][]byte{}
+
+	if v := h.Get("Content-MD5"); v != "" {
+		d, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(d) != md5.Size {
+			return nil, xerrors.Errorf("Content-MD5: %w", ErrInvalidDigest)
+		}
+		digests["md5"] = d
+	}
+
+	for _, v := range h.Values("Digest") {
+		for _, part := range strings.Split(v, ",") {
+			alg, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				return nil, xerrors.Errorf("Digest %q: %w", part, ErrInvalidDigest)
+			}
+
+			alg = strings.ToLower(alg)
+			size := map[string]int{"md5": md5.Size, "sha-256": sha256.Size}[alg]
+			if size == 0 {
+				continue
+			}
+
+			d, err := base64.StdEncoding.DecodeString(val)
+			if err != nil || len(d) != size {
+				return nil, xerrors.Errorf("Digest %s: %w", alg, ErrInvalidDigest)
+			}
+			digests[alg] = d
+		}
+	}
+
+	return digests, nil
+}
+
+// ChecksumReader computes the SHA-256 of everything read through it and, when the
+// client sent digests, verifies them once the end of the content is reached.
+// A mismatch is returned from Read in place of io.EOF, so storage discards the upload
+// instead of saving it
+type ChecksumReader struct {
+	r        io.Reader
+	sha256   hash.Hash
+	md5      hash.Hash
+	expected map[string][]byte
+}
+
+// NewChecksumReader wraps r, expected are the digests returned by ParseDigests and may be empty
+func NewChecksumReader(r io.Reader, expected map[string][]byte) *ChecksumReader {
+	return &ChecksumReader{r: r, sha256: sha256.New(), md5: md5.New(), expected: expected}
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.sha256.Write(p[:n])
+	c.md5.Write(p[:n])
+
+	if err == io.EOF {
+		if want, ok := c.expected["sha-256"]; ok && !bytes.Equal(want, c.sha256.Sum(nil)) {
+			return n, xerrors.Errorf("sha-256: %w", ErrChecksumMismatch)
+		}
+		if want, ok := c.expected["md5"]; ok && !bytes.Equal(want, c.md5.Sum(nil)) {
+			return n, xerrors.Errorf("md5: %w", ErrChecksumMismatch)
+		}
+	}
+
+	return n, err
+}
+
+// SHA256 returns the hex SHA-256 of the content read so far
+func (c *ChecksumReader) SHA256() string {
+	return hex.EncodeToString(c.sha256.Sum(nil))
+}
+
+// DigestHeader formats a hex SHA-256 as the value of a Digest header
+func DigestHeader(sha string) string {
+	d, err := hex.DecodeString(sha)
+	if err != nil {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(d)
+}
+
+// FileSHA256 returns the hex SHA-256 of the contents of r
+func FileSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", xerrors.Errorf("Unable to read file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
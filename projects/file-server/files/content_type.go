@@ -0,0 +1,110 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrUnsupportedType is returned when the sniffed content type is not in the allowlist
+var ErrUnsupportedType = xerrors.New("Unsupported content type")
+
+// ErrExtensionMismatch is returned when the filename extension does not match the sniffed content
+var ErrExtensionMismatch = xerrors.New("File extension does not match content")
+
+// sniffLen is the number of bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// TypeChecker validates uploads by sniffing their magic bytes
+// rather than trusting the filename or the Content-Type header sent by the client
+type TypeChecker struct {
+	allowed map[string]bool
+}
+
+// NewTypeChecker creates a TypeChecker accepting the given MIME types
+// e.g. image/png, image/jpeg. An empty list accepts any type and any extension,
+// uploads are still sniffed so the detected type can be recorded
+func NewTypeChecker(allowed []string) *TypeChecker {
+	tc := &TypeChecker{allowed: map[string]bool{}}
+	for _, t := range allowed {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			tc.allowed[t] = true
+		}
+	}
+
+	return tc
+}
+
+// Check sniffs the start of the contents and validates it against the allowlist and
+// the extension of filename. It returns the detected MIME type and a reader which
+// replays the sniffed bytes followed by the rest of the contents
+func (tc *TypeChecker) Check(filename string, contents io.Reader) (string, io.Reader, error) {
+	// read the head of the file, a short file is fine
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(contents, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, xerrors.Errorf("Unable to read file: %w", err)
+	}
+	head = head[:n]
+
+	detected := baseType(http.DetectContentType(head))
+
+	// the sniffer reports text formats such as JSON, CSV or SVG as text/plain or
+	// text/xml, the extension tells which one it is
+	ext := strings.ToLower(filepath.Ext(filename))
+	byExt := baseType(mime.TypeByExtension(ext))
+	if byExt != "" && textCompatible(byExt, detected) {
+		detected = byExt
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), contents)
+
+	// without an allowlist anything is accepted, the detected type is only recorded
+	if len(tc.allowed) == 0 {
+		return detected, body, nil
+	}
+
+	if !tc.allowed[detected] {
+		return detected, nil, xerrors.Errorf("Detected %s: %w", detected, ErrUnsupportedType)
+	}
+
+	// the extension has to agree with the content, a png called photo.jpg is rejected
+	switch {
+	case byExt == "":
+		// with an allowlist configured we cannot accept a name whose type we don't know
+		return detected, nil, xerrors.Errorf("Unknown extension %q: %w", ext, ErrExtensionMismatch)
+	case byExt != detected:
+		return detected, nil, xerrors.Errorf("Extension %q but detected %s: %w", ext, detected, ErrExtensionMismatch)
+	}
+
+	return detected, body, nil
+}
+
+// textCompatible reports whether content sniffed as detected can be of the text
+// based type byExt: text/plain can be any text format and text/xml any XML one
+func textCompatible(byExt, detected string) bool {
+	xml := byExt == "application/xml" || byExt == "text/xml" || strings.HasSuffix(byExt, "+xml")
+	switch detected {
+	case "text/xml":
+		return xml
+	case "text/plain":
+		return xml || strings.HasPrefix(byExt, "text/") || byExt == "application/json" ||
+			strings.HasSuffix(byExt, "+json") || byExt == "application/javascript"
+	}
+	return false
+}
+
+// baseType strips any parameters such as charset from a MIME type
+func baseType(t string) string {
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return strings.ToLower(t)
+	}
+	return mt
+}
@@ -0,0 +1,97 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestTypeCheckerAcceptsAllowedType(t *testing.T) {
+	tc := NewTypeChecker([]string{"image/png", "image/gif"})
+
+	ct, body, err := tc.Check("photo.png", bytes.NewReader(pngHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != "image/png" {
+		t.Fatalf("expected image/png, got %s", ct)
+	}
+
+	// the sniffed bytes must be replayed to the caller
+	d, _ := io.ReadAll(body)
+	if !bytes.Equal(d, pngHeader) {
+		t.Fatalf("body was not replayed, got %q", d)
+	}
+}
+
+func TestTypeCheckerRejections(t *testing.T) {
+	tc := NewTypeChecker([]string{"image/png", "image/gif"})
+
+	tests := []struct {
+		name     string
+		contents []byte
+		want     error
+	}{
+		{"notes.txt", []byte("plain text"), ErrUnsupportedType},
+		{"photo.gif", pngHeader, ErrExtensionMismatch},
+		{"photo", pngHeader, ErrExtensionMismatch},
+	}
+
+	for _, tt := range tests {
+		_, _, err := tc.Check(tt.name, bytes.NewReader(tt.contents))
+		if !xerrors.Is(err, tt.want) {
+			t.Errorf("Check(%q) expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestTypeCheckerAcceptsAnything(t *testing.T) {
+	tc := NewTypeChecker(nil)
+
+	tests := []struct {
+		name     string
+		contents []byte
+		want     string
+	}{
+		{"data.json", []byte(`{"a": 1}`), "application/json"},
+		{"logo.svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml"},
+		{"notes.txt", []byte("plain text"), "text/plain"},
+		{"photo.jpg", pngHeader, "image/png"},
+		{"blob", []byte{0, 1, 2}, "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		ct, _, err := tc.Check(tt.name, bytes.NewReader(tt.contents))
+		if err != nil {
+			t.Errorf("Check(%q) expected no error, got %v", tt.name, err)
+			continue
+		}
+		if ct != tt.want {
+			t.Errorf("Check(%q) expected %s, got %s", tt.name, tt.want, ct)
+		}
+	}
+}
+
+func TestTypeCheckerTextFormats(t *testing.T) {
+	tc := NewTypeChecker([]string{"application/json", "image/svg+xml"})
+
+	for _, name := range []string{"data.json", "logo.svg"} {
+		contents := `{"a": 1}`
+		if name == "logo.svg" {
+			contents = `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`
+		}
+		if _, _, err := tc.Check(name, bytes.NewReader([]byte(contents))); err != nil {
+			t.Errorf("Check(%q) expected no error, got %v", name, err)
+		}
+	}
+
+	// a text file can't pass as an image
+	_, _, err := tc.Check("logo.png", bytes.NewReader([]byte("plain text")))
+	if !xerrors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected %v, got %v", ErrUnsupportedType, err)
+	}
+}
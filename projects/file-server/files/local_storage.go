@@ -25,11 +25,19 @@ func NewLocalStorage(basePath string, maxSize int) (*LocalStorage, error) {
 		return nil, err
 	}
 
-	return &LocalStorage{basePath: p}, nil
+	// make sure the base path exists so an empty store can be listed
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create base directory: %w", err)
+	}
+
+	return &LocalStorage{basePath: p, maxFileSize: maxSize}, nil
 }
 
 // Save the contents of the Writer to the given path
 // path is a relative path, basePath will be appended
+// contents are streamed to a temporary file which replaces any existing file only once
+// the whole body has been written, so a failed or oversized upload never clobbers the old file
 func (l *LocalStorage) Save(path string, contents io.Reader) error {
 	// get the full path for the file
 	fp := l.fullPath(path)
@@ -41,33 +49,50 @@ func (l *LocalStorage) Save(path string, contents io.Reader) error {
 		return xerrors.Errorf("Unable to create directory: %w", err)
 	}
 
-	// if the file exists delete it
-	_, err = os.Stat(fp)
-	if err == nil {
-		err = os.Remove(fp)
-		if err != nil {
-			return xerrors.Errorf("Unable to delete file: %w", err)
-		}
-	} else if !os.IsNotExist(err) {
-		// if this is anything other than a not exists error
-		return xerrors.Errorf("Unable to get file info: %w", err)
+	// write to a temporary file next to the destination so the final rename is atomic
+	tmp, _, err := writeTemp(d, contents, l.maxFileSize, nil)
+	if err != nil {
+		return err
+	}
+
+	// move the new file into place, replacing any existing file
+	err = os.Rename(tmp, fp)
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("Unable to save file: %w", err)
 	}
 
-	// create a new file at the path
-	f, err := os.Create(fp)
+	return nil
+}
+
+// writeTemp streams contents into a new hidden temporary file in dir, also feeding
+// them to w when it is not nil, e.g. to hash the file while it is written.
+// ensure that we are not writing greater than max bytes, reading one extra byte
+// tells us whether the source had more data than allowed.
+// It returns the name of the temporary file and the number of bytes written
+func writeTemp(dir string, contents io.Reader, max int, w io.Writer) (string, int64, error) {
+	f, err := os.CreateTemp(dir, ".upload-*")
 	if err != nil {
-		return xerrors.Errorf("Unable to create file: %w", err)
+		return "", 0, xerrors.Errorf("Unable to create file: %w", err)
 	}
-	defer f.Close()
 
-	// write the contents to the new file
-	// ensure that we are not writing greater than max bytes
-	_, err = io.Copy(f, contents)
+	var dst io.Writer = f
+	if w != nil {
+		dst = io.MultiWriter(f, w)
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(contents, int64(max)+1))
+	f.Close()
 	if err != nil {
-		return xerrors.Errorf("Unable to write to file: %w", err)
+		os.Remove(f.Name())
+		return "", 0, xerrors.Errorf("Unable to write to file: %w", err)
+	}
+	if n > int64(max) {
+		os.Remove(f.Name())
+		return "", 0, ErrFileTooLarge
 	}
 
-	return nil
+	return f.Name(), n, nil
 }
 
 // Get the file at the given path and return a Reader
@@ -85,16 +110,57 @@ func (l *LocalStorage) Get(path string) (*os.File, error) {
 	return f, nil
 }
 
+// Exists reports whether a regular file is stored at the given path
+func (l *LocalStorage) Exists(path string) (bool, error) {
+	fi, err := os.Stat(l.fullPath(path))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, xerrors.Errorf("Unable to stat file: %w", err)
+	}
+
+	return fi.Mode().IsRegular(), nil
+}
+
 // ListFiles returns a list of all files in the storage
 func (l *LocalStorage) ListFiles() ([]FileInfo, error) {
+	return l.walk(l.basePath)
+}
+
+// ListFilesByID returns the files stored for one id, only that id's directory is read
+func (l *LocalStorage) ListFilesByID(id string) ([]FileInfo, error) {
+	if !validID(id) {
+		return nil, ErrInvalidFilename
+	}
+
+	root := l.fullPath(id)
+	_, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return []FileInfo{}, nil
+	}
+
+	return l.walk(root)
+}
+
+// walk lists the files found under root, which is the base path or an id directory
+func (l *LocalStorage) walk(root string) ([]FileInfo, error) {
 	var files []FileInfo
 
 	// Walk through all directories and files
-	err := filepath.Walk(l.basePath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		// Skip hidden files and directories such as in-progress uploads
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Skip directories, only process files
 		if !info.IsDir() {
 			// Get relative path from basePath
@@ -115,6 +181,7 @@ func (l *LocalStorage) ListFiles() ([]FileInfo, error) {
 					Filename: filename,
 					Size:     info.Size(),
 					Path:     relativePath,
+					ModTime:  info.ModTime().UTC(),
 				})
 			}
 		}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestSaveRejectsOversizedFileAndKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocalStorage(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Save("1/a.txt", bytes.NewBufferString("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Save("1/a.txt", bytes.NewBufferString("this is far too long"))
+	if !xerrors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	d, err := os.ReadFile(filepath.Join(dir, "1", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != "original" {
+		t.Fatalf("expected original contents to survive, got %q", d)
+	}
+
+	fs, err := l.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 {
+		t.Fatalf("expected 1 file, got %d: %v", len(fs), fs)
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := map[string]string{
+		"photo.png":            "photo.png",
+		"C:\\Users\\me\\a.jpg": "a.jpg",
+		"dir/b.gif":            "b.gif",
+		"..":                   "",
+		".hidden":              "",
+		"":                     "",
+	}
+
+	for in, want := range tests {
+		got, err := CleanFilename(in)
+		if want == "" {
+			if err == nil {
+				t.Errorf("CleanFilename(%q) expected error, got %q", in, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("CleanFilename(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestSaveDiscardsUploadWithMismatchedDigest(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocalStorage(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.Header{}
+	h.Set("Digest", "sha-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=") // sha-256 of ""
+	digests, err := ParseDigests(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Save("1/a.txt", NewChecksumReader(bytes.NewBufferString("not empty"), digests))
+	if !xerrors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1", "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected file not to be saved, got %v", err)
+	}
+
+	cr := NewChecksumReader(bytes.NewBufferString(""), digests)
+	if err := l.Save("1/a.txt", cr); err != nil {
+		t.Fatal(err)
+	}
+	if cr.SHA256() != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatalf("unexpected sha256 %s", cr.SHA256())
+	}
+}
+
+func TestExists(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocalStorage(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCASStorage(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []Storage{l, c} {
+		err = s.Save("1/a.txt", bytes.NewBufferString("contents"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a directory is not a file
+		for p, want := range map[string]bool{"1/a.txt": true, "1/b.txt": false, "2/a.txt": false, "1": false} {
+			ok, err := s.Exists(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != want {
+				t.Errorf("%T: Exists(%q) expected %v, got %v", s, p, want, ok)
+			}
+		}
+	}
+}
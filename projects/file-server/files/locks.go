@@ -0,0 +1,54 @@
+package files
+
+import "sync"
+
+// KeyedMutex hands out one lock per key, e.g. per upload or per file. A key's
+// lock only exists while somebody holds or waits for it, so locking keys which
+// turn out not to exist doesn't leave anything behind. The zero value is ready to use
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock of one key, counting its holders and waiters
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the func which unlocks it
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// len returns the number of keys locked or waited for
+func (k *KeyedMutex) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.locks)
+}
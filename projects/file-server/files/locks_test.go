@@ -0,0 +1,33 @@
+package files
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var k KeyedMutex
+
+	unlock := k.Lock("1/a.png")
+
+	// other keys are not held up
+	k.Lock("1/b.png")()
+
+	locked := make(chan struct{})
+	go func() {
+		k.Lock("1/a.png")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("expected the second lock of the key to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	if n := k.len(); n != 0 {
+		t.Errorf("expected released locks to be dropped, got %d", n)
+	}
+}
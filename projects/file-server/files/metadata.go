@@ -0,0 +1,183 @@
+package files
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrMetadataNotFound is returned when no metadata has been recorded for a file
+var ErrMetadataNotFound = xerrors.New("Metadata not found")
+
+// ErrInvalidMetadata is returned when client supplied metadata fails validation
+var ErrInvalidMetadata = xerrors.New("Invalid metadata")
+
+// limits for the client editable metadata
+const (
+	maxLabels           = 32
+	maxLabelKeyLen      = 63
+	maxLabelValueLen    = 256
+	maxDescriptionBytes = 4096
+)
+
+// metaDir is the hidden directory under the base path holding the sidecar files,
+// storage listings skip hidden directories so the sidecars never show up as files
+const metaDir = ".meta"
+
+// Metadata holds the information recorded about a stored file
+// content type, checksum, uploader and upload time are set by the server when the
+// file is uploaded, description and labels are edited by clients
+type Metadata struct {
+	ContentType string     `json:"content_type,omitempty"`
+	SHA256      string     `json:"sha256,omitempty"` // hex SHA-256 of the content
+	Uploader    string     `json:"uploader,omitempty"`
+	UploadedAt  *time.Time `json:"uploaded_at,omitempty"`
+
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Validate checks the client editable fields are within our limits
+func (md *Metadata) Validate() error {
+	if len(md.Description) > maxDescriptionBytes {
+		return xerrors.Errorf("description longer than %d bytes: %w", maxDescriptionBytes, ErrInvalidMetadata)
+	}
+	if len(md.Labels) > maxLabels {
+		return xerrors.Errorf("more than %d labels: %w", maxLabels, ErrInvalidMetadata)
+	}
+
+	for k, v := range md.Labels {
+		if k == "" || len(k) > maxLabelKeyLen {
+			return xerrors.Errorf("label keys must be 1 to %d characters: %w", maxLabelKeyLen, ErrInvalidMetadata)
+		}
+		if len(v) > maxLabelValueLen {
+			return xerrors.Errorf("label %q longer than %d characters: %w", k, maxLabelValueLen, ErrInvalidMetadata)
+		}
+	}
+
+	return nil
+}
+
+// MetadataStore persists Metadata as a JSON sidecar per file, the sidecar for
+// {id}/{filename} lives at {basePath}/.meta/{id}/{filename}.json
+type MetadataStore struct {
+	basePath string
+
+	// serialises read-modify-write updates
+	mu sync.Mutex
+}
+
+// NewMetadataStore creates a MetadataStore keeping its sidecars under basePath
+func NewMetadataStore(basePath string) (*MetadataStore, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, metaDir))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetadataStore{basePath: p}, nil
+}
+
+// Get returns the metadata for the file at the given relative path
+func (m *MetadataStore) Get(path string) (*Metadata, error) {
+	d, err := os.ReadFile(m.sidecarPath(path))
+	if os.IsNotExist(err) {
+		return nil, ErrMetadataNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read metadata: %w", err)
+	}
+
+	md := &Metadata{}
+	err = json.Unmarshal(d, md)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode metadata: %w", err)
+	}
+
+	return md, nil
+}
+
+// Put records the metadata for the file at the given relative path
+// replacing anything stored before
+func (m *MetadataStore) Put(path string, md *Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.write(path, md)
+}
+
+// Update loads the metadata for the file at path, calls fn to modify it and stores
+// the result. Missing metadata starts out empty, an error from fn aborts the update
+func (m *MetadataStore) Update(path string, fn func(md *Metadata) error) (*Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	md, err := m.Get(path)
+	if xerrors.Is(err, ErrMetadataNotFound) {
+		md, err = &Metadata{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = fn(md)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.write(path, md)
+	if err != nil {
+		return nil, err
+	}
+
+	return md, nil
+}
+
+// write stores the sidecar, must be called with the lock held
+func (m *MetadataStore) write(path string, md *Metadata) error {
+	sp := m.sidecarPath(path)
+
+	err := os.MkdirAll(filepath.Dir(sp), os.ModePerm)
+	if err != nil {
+		return xerrors.Errorf("Unable to create metadata directory: %w", err)
+	}
+
+	d, err := json.Marshal(md)
+	if err != nil {
+		return xerrors.Errorf("Unable to encode metadata: %w", err)
+	}
+
+	// write then rename so a reader never sees a half written sidecar
+	tmp := sp + ".tmp"
+	err = os.WriteFile(tmp, d, 0o644)
+	if err != nil {
+		return xerrors.Errorf("Unable to write metadata: %w", err)
+	}
+
+	err = os.Rename(tmp, sp)
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("Unable to write metadata: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the metadata for the file at the given relative path,
+// deleting metadata which does not exist is not an error
+func (m *MetadataStore) Delete(path string) error {
+	err := os.Remove(m.sidecarPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("Unable to delete metadata: %w", err)
+	}
+
+	return nil
+}
+
+// returns the absolute path of the sidecar for a file
+func (m *MetadataStore) sidecarPath(path string) string {
+	return filepath.Join(m.basePath, path+".json")
+}
@@ -0,0 +1,102 @@
+package files
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestMetadataValidate(t *testing.T) {
+	manyLabels := map[string]string{}
+	for i := 0; i <= maxLabels; i++ {
+		manyLabels[strings.Repeat("k", i+1)] = "v"
+	}
+
+	tests := []struct {
+		name string
+		md   Metadata
+		ok   bool
+	}{
+		{"empty", Metadata{}, true},
+		{"within limits", Metadata{Description: strings.Repeat("d", maxDescriptionBytes), Labels: map[string]string{"color": strings.Repeat("v", maxLabelValueLen)}}, true},
+		{"long description", Metadata{Description: strings.Repeat("d", maxDescriptionBytes+1)}, false},
+		{"too many labels", Metadata{Labels: manyLabels}, false},
+		{"empty key", Metadata{Labels: map[string]string{"": "v"}}, false},
+		{"long key", Metadata{Labels: map[string]string{strings.Repeat("k", maxLabelKeyLen+1): "v"}}, false},
+		{"long value", Metadata{Labels: map[string]string{"color": strings.Repeat("v", maxLabelValueLen+1)}}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.md.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if !tt.ok && !xerrors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%s: expected ErrInvalidMetadata, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestMetadataStoreUpdate(t *testing.T) {
+	m, err := NewMetadataStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing recorded for an unknown file
+	_, err = m.Get("1/a.png")
+	if !xerrors.Is(err, ErrMetadataNotFound) {
+		t.Fatalf("expected ErrMetadataNotFound, got %v", err)
+	}
+
+	// an update of an unknown file starts out empty
+	_, err = m.Update("1/a.png", func(md *Metadata) error {
+		if md.ContentType != "" || md.Description != "" {
+			t.Errorf("expected empty metadata, got %+v", md)
+		}
+		md.ContentType = "image/png"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := m.Update("1/a.png", func(md *Metadata) error {
+		md.Description = "front"
+		md.Labels = map[string]string{"color": "red"}
+		return md.Validate()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.ContentType != "image/png" || md.Description != "front" {
+		t.Errorf("expected the update to keep the content type, got %+v", md)
+	}
+
+	// an invalid update is not stored
+	_, err = m.Update("1/a.png", func(md *Metadata) error {
+		md.Description = strings.Repeat("d", maxDescriptionBytes+1)
+		return md.Validate()
+	})
+	if !xerrors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("expected ErrInvalidMetadata, got %v", err)
+	}
+
+	md, err = m.Get("1/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Description != "front" || md.Labels["color"] != "red" {
+		t.Errorf("expected the stored metadata to be unchanged, got %+v", md)
+	}
+
+	err = m.Delete("1/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Get("1/a.png")
+	if !xerrors.Is(err, ErrMetadataNotFound) {
+		t.Fatalf("expected ErrMetadataNotFound after Delete, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package files
+
+import (
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidFilename is returned when a client supplied filename is not safe to store
+var ErrInvalidFilename = xerrors.New("Invalid filename")
+
+// CleanFilename turns a client supplied filename into a name that is safe to store
+// inside an id directory. Browsers may send a full path for multipart uploads, so only
+// the last element is kept. Empty names, hidden files and names that would escape the
+// id directory are rejected with ErrInvalidFilename.
+func CleanFilename(name string) (string, error) {
+	// treat windows separators the same as forward slashes before taking the base name
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "", ErrInvalidFilename
+	}
+
+	// hidden files are reserved for the storage's own bookkeeping
+	if strings.HasPrefix(name, ".") {
+		return "", ErrInvalidFilename
+	}
+
+	// control characters have no business in a filename
+	if strings.IndexFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return "", ErrInvalidFilename
+	}
+
+	return name, nil
+}
+
+// validID reports whether id can be used as a directory name under the base path
+func validID(id string) bool {
+	name, err := CleanFilename(id)
+	return err == nil && name == id
+}
@@ -0,0 +1,249 @@
+package files
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidQuery is returned when the listing query parameters can't be used
+var ErrInvalidQuery = xerrors.New("Invalid query")
+
+// MaxListLimit is the largest page size a client can ask for
+const MaxListLimit = 1000
+
+// sort orders supported by ListQuery, prefix with - for descending
+var sortFields = map[string]bool{"path": true, "filename": true, "size": true, "mod_time": true}
+
+// ListQuery filters, sorts and pages a file listing
+type ListQuery struct {
+	ID             string    // only files for this id
+	Glob           string    // filename pattern, e.g. *.png
+	MinSize        int64     // -1 when not set
+	MaxSize        int64     // -1 when not set
+	ModifiedAfter  time.Time // zero when not set
+	ModifiedBefore time.Time // zero when not set
+	Sort           string    // one of sortFields
+	Desc           bool
+	Limit          int // 0 returns everything
+	Cursor         string
+}
+
+// cursor marks the last item of a page, the next page starts after it.
+// It holds the values the listing is sorted by, so it stays valid when
+// files before it are added or removed
+type cursor struct {
+	Sort     string    `json:"s"`
+	Desc     bool      `json:"d,omitempty"`
+	Path     string    `json:"p"`
+	Filename string    `json:"f,omitempty"`
+	Size     int64     `json:"z,omitempty"`
+	ModTime  time.Time `json:"m,omitempty"`
+}
+
+// ParseListQuery reads a ListQuery from the query parameters
+// id, name, min_size, max_size, modified_after, modified_before (RFC 3339),
+// sort (path, filename, size or mod_time, prefixed with - for descending), limit and cursor
+func ParseListQuery(q url.Values) (*ListQuery, error) {
+	lq := &ListQuery{
+		ID:      q.Get("id"),
+		Glob:    q.Get("name"),
+		MinSize: -1,
+		MaxSize: -1,
+		Sort:    "path",
+		Cursor:  q.Get("cursor"),
+	}
+
+	var err error
+	if lq.ID != "" && !validID(lq.ID) {
+		return nil, xerrors.Errorf("id: %w", ErrInvalidQuery)
+	}
+
+	// check the pattern is well formed, path.Match only reports errors when matching
+	if _, err = path.Match(lq.Glob, ""); err != nil {
+		return nil, xerrors.Errorf("name: %w", ErrInvalidQuery)
+	}
+
+	if lq.MinSize, err = parseSize(q.Get("min_size")); err != nil {
+		return nil, xerrors.Errorf("min_size: %w", ErrInvalidQuery)
+	}
+	if lq.MaxSize, err = parseSize(q.Get("max_size")); err != nil {
+		return nil, xerrors.Errorf("max_size: %w", ErrInvalidQuery)
+	}
+
+	if lq.ModifiedAfter, err = parseTime(q.Get("modified_after")); err != nil {
+		return nil, xerrors.Errorf("modified_after: %w", ErrInvalidQuery)
+	}
+	if lq.ModifiedBefore, err = parseTime(q.Get("modified_before")); err != nil {
+		return nil, xerrors.Errorf("modified_before: %w", ErrInvalidQuery)
+	}
+
+	if s := q.Get("sort"); s != "" {
+		lq.Desc = strings.HasPrefix(s, "-")
+		lq.Sort = strings.TrimPrefix(s, "-")
+		if !sortFields[lq.Sort] {
+			return nil, xerrors.Errorf("sort must be path, filename, size or mod_time: %w", ErrInvalidQuery)
+		}
+	}
+
+	if l := q.Get("limit"); l != "" {
+		lq.Limit, err = strconv.Atoi(l)
+		if err != nil || lq.Limit < 1 || lq.Limit > MaxListLimit {
+			return nil, xerrors.Errorf("limit must be between 1 and %d: %w", MaxListLimit, ErrInvalidQuery)
+		}
+	}
+
+	return lq, nil
+}
+
+// Apply filters and sorts the files and returns the requested page, along with
+// the cursor for the next page which is empty when this is the last page
+func (lq *ListQuery) Apply(files []FileInfo) ([]FileInfo, string, error) {
+	after, err := lq.decodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	matched := []FileInfo{}
+	for _, fi := range files {
+		if lq.match(fi) {
+			matched = append(matched, fi)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return lq.less(matched[i], matched[j]) })
+
+	// skip everything up to and including the cursor
+	if after != nil {
+		start := sort.Search(len(matched), func(i int) bool { return lq.less(*after, matched[i]) })
+		matched = matched[start:]
+	}
+
+	if lq.Limit == 0 || len(matched) <= lq.Limit {
+		return matched, "", nil
+	}
+
+	page := matched[:lq.Limit]
+	return page, lq.encodeCursor(page[len(page)-1]), nil
+}
+
+// match reports whether the file passes the filters
+func (lq *ListQuery) match(fi FileInfo) bool {
+	if lq.ID != "" && fi.ID != lq.ID {
+		return false
+	}
+	if lq.Glob != "" {
+		if ok, _ := path.Match(lq.Glob, fi.Filename); !ok {
+			return false
+		}
+	}
+	if lq.MinSize >= 0 && fi.Size < lq.MinSize {
+		return false
+	}
+	if lq.MaxSize >= 0 && fi.Size > lq.MaxSize {
+		return false
+	}
+	if !lq.ModifiedAfter.IsZero() && !fi.ModTime.After(lq.ModifiedAfter) {
+		return false
+	}
+	if !lq.ModifiedBefore.IsZero() && !fi.ModTime.Before(lq.ModifiedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// less orders files by the sort field, ties are broken by path so the order is total
+func (lq *ListQuery) less(a, b FileInfo) bool {
+	var c int
+	switch lq.Sort {
+	case "filename":
+		c = strings.Compare(a.Filename, b.Filename)
+	case "size":
+		c = compareInt(a.Size, b.Size)
+	case "mod_time":
+		c = a.ModTime.Compare(b.ModTime)
+	}
+	if c == 0 {
+		c = strings.Compare(filepath.ToSlash(a.Path), filepath.ToSlash(b.Path))
+	}
+
+	if lq.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (lq *ListQuery) encodeCursor(last FileInfo) string {
+	d, _ := json.Marshal(cursor{
+		Sort:     lq.Sort,
+		Desc:     lq.Desc,
+		Path:     last.Path,
+		Filename: last.Filename,
+		Size:     last.Size,
+		ModTime:  last.ModTime,
+	})
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+// decodeCursor returns the file the page should start after, nil for the first page
+func (lq *ListQuery) decodeCursor() (*FileInfo, error) {
+	if lq.Cursor == "" {
+		return nil, nil
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(lq.Cursor)
+	if err != nil {
+		return nil, xerrors.Errorf("cursor: %w", ErrInvalidQuery)
+	}
+
+	c := cursor{}
+	err = json.Unmarshal(d, &c)
+	if err != nil {
+		return nil, xerrors.Errorf("cursor: %w", ErrInvalidQuery)
+	}
+
+	// a cursor only makes sense for the order it was created with
+	if c.Sort != lq.Sort || c.Desc != lq.Desc {
+		return nil, xerrors.Errorf("cursor does not match sort: %w", ErrInvalidQuery)
+	}
+
+	return &FileInfo{Path: c.Path, Filename: c.Filename, Size: c.Size, ModTime: c.ModTime}, nil
+}
+
+func parseSize(v string) (int64, error) {
+	if v == "" {
+		return -1, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, xerrors.New("must be a positive number")
+	}
+	return n, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
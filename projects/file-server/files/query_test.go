@@ -0,0 +1,61 @@
+package files
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestListQueryFiltersAndPages(t *testing.T) {
+	now := time.Now()
+	fs := []FileInfo{
+		{ID: "1", Filename: "a.png", Path: "1/a.png", Size: 10, ModTime: now},
+		{ID: "1", Filename: "b.jpg", Path: "1/b.jpg", Size: 30, ModTime: now},
+		{ID: "2", Filename: "c.png", Path: "2/c.png", Size: 20, ModTime: now},
+		{ID: "2", Filename: "d.png", Path: "2/d.png", Size: 40, ModTime: now},
+	}
+
+	q, _ := url.ParseQuery("name=*.png&sort=-size&limit=2")
+	lq, err := ParseListQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, next, err := lq.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Path != "2/d.png" || page[1].Path != "2/c.png" || next == "" {
+		t.Fatalf("unexpected first page %+v next %q", page, next)
+	}
+
+	q.Set("cursor", next)
+	lq, err = ParseListQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, next, err = lq.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Path != "1/a.png" || next != "" {
+		t.Fatalf("unexpected last page %+v next %q", page, next)
+	}
+
+	// a cursor from another sort order is rejected
+	q.Set("sort", "size")
+	lq, _ = ParseListQuery(q)
+	if _, _, err := lq.Apply(fs); err == nil {
+		t.Fatal("expected error for mismatched cursor")
+	}
+}
+
+func TestParseListQueryRejectsBadInput(t *testing.T) {
+	bad := []string{"sort=owner", "limit=0", "limit=5000", "min_size=-1", "modified_after=yesterday", "id=..", "name=[a"}
+	for _, raw := range bad {
+		q, _ := url.ParseQuery(raw)
+		if _, err := ParseListQuery(q); err == nil {
+			t.Errorf("ParseListQuery(%q) expected error", raw)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrQuotaExceeded is matched by every QuotaError
+var ErrQuotaExceeded = xerrors.New("Quota exceeded")
+
+// Quota scopes and resources reported in a QuotaError
+const (
+	QuotaScopeID     = "id"
+	QuotaScopeGlobal = "global"
+
+	QuotaBytes = "bytes"
+	QuotaFiles = "files"
+)
+
+// QuotaError describes which quota a save would have exceeded
+type QuotaError struct {
+	Scope    string `json:"scope"`
+	ID       string `json:"id,omitempty"`
+	Resource string `json:"resource"`
+	Limit    int64  `json:"limit"`
+	Used     int64  `json:"used"`
+}
+
+func (e *QuotaError) Error() string {
+	if e.Scope == QuotaScopeID {
+		return fmt.Sprintf("Quota exceeded for id %s: %d of %d %s used", e.ID, e.Used, e.Limit, e.Resource)
+	}
+	return fmt.Sprintf("Global quota exceeded: %d of %d %s used", e.Used, e.Limit, e.Resource)
+}
+
+// Is lets xerrors.Is(err, ErrQuotaExceeded) match any QuotaError
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaLimits are the limits enforced by QuotaStorage, zero means unlimited
+type QuotaLimits struct {
+	IDBytes    int64 // bytes per id
+	IDFiles    int64 // files per id
+	TotalBytes int64 // bytes across all ids
+	TotalFiles int64 // files across all ids
+}
+
+// Usage is the space used by an id or by the whole storage
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// QuotaStorage wraps a Storage and enforces quotas on Save.
+// Usage is counted once from the wrapped storage's listing when it is created and
+// then updated as files are saved and deleted, so checking a quota never walks the disk
+type QuotaStorage struct {
+	Storage
+	limits QuotaLimits
+
+	mu      sync.Mutex
+	sizes   map[string]int64 // path -> size of every stored file
+	used    map[string]*Usage
+	total   Usage
+	pending map[string]*Usage // bytes and new files of saves in progress, per id
+	pendAll Usage
+}
+
+// NewQuotaStorage wraps s, enforcing the given limits
+func NewQuotaStorage(s Storage, limits QuotaLimits) (*QuotaStorage, error) {
+	q := &QuotaStorage{
+		Storage: s,
+		limits:  limits,
+		sizes:   map[string]int64{},
+		used:    map[string]*Usage{},
+		pending: map[string]*Usage{},
+	}
+
+	fs, err := s.ListFiles()
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to count usage: %w", err)
+	}
+
+	for _, fi := range fs {
+		key := quotaKey(fi.Path)
+		q.sizes[key] = fi.Size
+		q.usage(fi.ID).Bytes += fi.Size
+		q.usage(fi.ID).Files++
+		q.total.Bytes += fi.Size
+		q.total.Files++
+	}
+
+	return q, nil
+}
+
+// Save the contents to the given path, refusing with a QuotaError when the
+// file would take its id or the whole storage over a quota
+func (q *QuotaStorage) Save(path string, contents io.Reader) error {
+	key := quotaKey(path)
+	id := strings.SplitN(key, "/", 2)[0]
+
+	q.mu.Lock()
+	old, exists := q.sizes[key]
+
+	// a new name counts against the file quotas, reserve it while we save
+	if !exists {
+		err := q.checkFiles(id)
+		if err != nil {
+			q.mu.Unlock()
+			return err
+		}
+		q.reserved(id).Files++
+		q.pendAll.Files++
+	}
+	q.mu.Unlock()
+
+	qr := &quotaReader{q: q, r: contents, id: id, old: old}
+	err := q.Storage.Save(path, qr)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// release the reservation, on success replace it with the real usage
+	q.reserved(id).Bytes -= qr.n
+	q.pendAll.Bytes -= qr.n
+	if !exists {
+		q.reserved(id).Files--
+		q.pendAll.Files--
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// another save or delete of the same path may have finished while we were
+	// writing, so count against what is stored now rather than what was there before
+	cur, stored := q.sizes[key]
+	q.sizes[key] = qr.n
+	q.usage(id).Bytes += qr.n - cur
+	q.total.Bytes += qr.n - cur
+	if !stored {
+		q.usage(id).Files++
+		q.total.Files++
+	}
+
+	return nil
+}
+
+// DeleteFile deletes a file at the given path and releases its usage
+func (q *QuotaStorage) DeleteFile(path string) error {
+	err := q.Storage.DeleteFile(path)
+	if err != nil {
+		return err
+	}
+
+	key := quotaKey(path)
+	id := strings.SplitN(key, "/", 2)[0]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size, ok := q.sizes[key]
+	if !ok {
+		return nil
+	}
+
+	delete(q.sizes, key)
+	q.usage(id).Bytes -= size
+	q.usage(id).Files--
+	q.total.Bytes -= size
+	q.total.Files--
+
+	return nil
+}
+
+// Usage returns the space used by an id
+func (q *QuotaStorage) Usage(id string) Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if u, ok := q.used[id]; ok {
+		return *u
+	}
+	return Usage{}
+}
+
+// TotalUsage returns the space used by the whole storage
+func (q *QuotaStorage) TotalUsage() Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.total
+}
+
+// Limits returns the configured limits
+func (q *QuotaStorage) Limits() QuotaLimits {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.limits
+}
+
+// SetLimits replaces the limits, saves already in progress keep what they reserved
+// and files over a lowered limit stay until they are deleted
+func (q *QuotaStorage) SetLimits(limits QuotaLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.limits = limits
+}
+
+// checkFiles checks one more file fits, must be called with the lock held
+func (q *QuotaStorage) checkFiles(id string) error {
+	used := q.usage(id).Files + q.reserved(id).Files
+	if q.limits.IDFiles > 0 && used+1 > q.limits.IDFiles {
+		return &QuotaError{Scope: QuotaScopeID, ID: id, Resource: QuotaFiles, Limit: q.limits.IDFiles, Used: used}
+	}
+
+	used = q.total.Files + q.pendAll.Files
+	if q.limits.TotalFiles > 0 && used+1 > q.limits.TotalFiles {
+		return &QuotaError{Scope: QuotaScopeGlobal, Resource: QuotaFiles, Limit: q.limits.TotalFiles, Used: used}
+	}
+
+	return nil
+}
+
+// reserve accounts for n more bytes being written by a save which replaces a file
+// of size old, must be called with the lock held
+func (q *QuotaStorage) reserve(id string, n, old int64) error {
+	// the file being replaced frees its space once the save completes
+	used := q.usage(id).Bytes + q.reserved(id).Bytes - old
+	if q.limits.IDBytes > 0 && used+n > q.limits.IDBytes {
+		return &QuotaError{Scope: QuotaScopeID, ID: id, Resource: QuotaBytes, Limit: q.limits.IDBytes, Used: q.usage(id).Bytes}
+	}
+
+	used = q.total.Bytes + q.pendAll.Bytes - old
+	if q.limits.TotalBytes > 0 && used+n > q.limits.TotalBytes {
+		return &QuotaError{Scope: QuotaScopeGlobal, Resource: QuotaBytes, Limit: q.limits.TotalBytes, Used: q.total.Bytes}
+	}
+
+	q.reserved(id).Bytes += n
+	q.pendAll.Bytes += n
+	return nil
+}
+
+func (q *QuotaStorage) usage(id string) *Usage {
+	u, ok := q.used[id]
+	if !ok {
+		u = &Usage{}
+		q.used[id] = u
+	}
+	return u
+}
+
+func (q *QuotaStorage) reserved(id string) *Usage {
+	u, ok := q.pending[id]
+	if !ok {
+		u = &Usage{}
+		q.pending[id] = u
+	}
+	return u
+}
+
+// quotaReader reserves quota for every chunk before handing it to the storage,
+// so an upload is stopped as soon as it crosses a quota rather than after it is stored
+type quotaReader struct {
+	q   *QuotaStorage
+	r   io.Reader
+	id  string
+	old int64 // size of the file being replaced
+	n   int64 // bytes reserved so far
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	if n > 0 {
+		qr.q.mu.Lock()
+		rerr := qr.q.reserve(qr.id, int64(n), qr.old)
+		qr.q.mu.Unlock()
+		if rerr != nil {
+			return 0, rerr
+		}
+		qr.n += int64(n)
+	}
+	return n, err
+}
+
+// quotaKey normalises a relative path so the same file always has the same key
+func quotaKey(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
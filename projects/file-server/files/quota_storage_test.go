@@ -0,0 +1,113 @@
+package files
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestQuotaStorageEnforcesLimits(t *testing.T) {
+	l, err := NewLocalStorage(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a file stored before the quota is counted at start up
+	if err := l.Save("1/old.png", bytes.NewBufferString("12345")); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewQuotaStorage(l, QuotaLimits{IDBytes: 10, IDFiles: 2, TotalBytes: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := q.Usage("1"); u.Bytes != 5 || u.Files != 1 {
+		t.Fatalf("unexpected initial usage %+v", u)
+	}
+
+	// over the id byte quota
+	err = q.Save("1/a.png", bytes.NewBufferString("123456"))
+	var qe *QuotaError
+	if !xerrors.As(err, &qe) || qe.Scope != QuotaScopeID || qe.Resource != QuotaBytes {
+		t.Fatalf("expected id bytes quota error, got %v", err)
+	}
+
+	// replacing a file only counts the difference
+	if err := q.Save("1/old.png", bytes.NewBufferString("1234567890")); err != nil {
+		t.Fatal(err)
+	}
+
+	// over the id file quota
+	if err := q.Save("1/b.png", bytes.NewBufferString("")); err != nil {
+		t.Fatal(err)
+	}
+	err = q.Save("1/c.png", bytes.NewBufferString(""))
+	if !xerrors.As(err, &qe) || qe.Resource != QuotaFiles {
+		t.Fatalf("expected id files quota error, got %v", err)
+	}
+
+	// over the global byte quota
+	err = q.Save("2/a.png", bytes.NewBufferString(strings.Repeat("x", 9)))
+	if !xerrors.As(err, &qe) || qe.Scope != QuotaScopeGlobal || !xerrors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected global quota error, got %v", err)
+	}
+
+	// deleting frees the space
+	if err := q.DeleteFile("1/old.png"); err != nil {
+		t.Fatal(err)
+	}
+	if u := q.TotalUsage(); u.Bytes != 0 || u.Files != 1 {
+		t.Fatalf("unexpected usage after delete %+v", u)
+	}
+}
+
+// gatedReader signals its first read and waits for release before returning data
+type gatedReader struct {
+	r       *bytes.Buffer
+	reading chan struct{}
+	release chan struct{}
+	started bool
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	if !g.started {
+		g.started = true
+		close(g.reading)
+		<-g.release
+	}
+	return g.r.Read(p)
+}
+
+func TestQuotaStorageConcurrentSaves(t *testing.T) {
+	l, err := NewLocalStorage(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQuotaStorage(l, QuotaLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow save of a new file is overtaken by a save of the same name
+	g := &gatedReader{r: bytes.NewBufferString("1234567"), reading: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- q.Save("1/a.png", g) }()
+	<-g.reading
+
+	if err := q.Save("1/a.png", bytes.NewBufferString("123")); err != nil {
+		t.Fatal(err)
+	}
+	close(g.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is counted once, with the size of the save which finished last
+	if u := q.Usage("1"); u.Bytes != 7 || u.Files != 1 {
+		t.Fatalf("unexpected usage %+v", u)
+	}
+	if u := q.TotalUsage(); u.Bytes != 7 || u.Files != 1 {
+		t.Fatalf("unexpected total usage %+v", u)
+	}
+}
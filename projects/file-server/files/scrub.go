@@ -0,0 +1,62 @@
+package files
+
+import "golang.org/x/xerrors"
+
+// Scrub results for a single file
+const (
+	ScrubOK         = "ok"
+	ScrubCorrupt    = "corrupt"
+	ScrubUnverified = "unverified" // no checksum was recorded for the file
+	ScrubUnreadable = "unreadable"
+)
+
+// ScrubResult is the outcome of verifying one stored file
+type ScrubResult struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Scrub re-reads every file in the storage and compares its SHA-256 with the
+// checksum recorded in the metadata at upload time
+func Scrub(s Storage, m *MetadataStore) ([]ScrubResult, error) {
+	fs, err := s.ListFiles()
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to list files: %w", err)
+	}
+
+	results := []ScrubResult{}
+	for _, fi := range fs {
+		res := ScrubResult{Path: fi.Path}
+
+		md, err := m.Get(fi.Path)
+		if err != nil || md.SHA256 == "" {
+			res.Status = ScrubUnverified
+			results = append(results, res)
+			continue
+		}
+		res.Expected = md.SHA256
+
+		f, err := s.Get(fi.Path)
+		if err != nil {
+			res.Status = ScrubUnreadable
+			results = append(results, res)
+			continue
+		}
+		res.Actual, err = FileSHA256(f)
+		f.Close()
+
+		switch {
+		case err != nil:
+			res.Status = ScrubUnreadable
+		case res.Actual != res.Expected:
+			res.Status = ScrubCorrupt
+		default:
+			res.Status = ScrubOK
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
@@ -1,6 +1,15 @@
 package files
 
-import "io"
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrFileTooLarge is returned by Save when the contents exceed the maximum file size
+var ErrFileTooLarge = xerrors.New("File exceeds the maximum allowed size")
 
 // FileInfo represents basic file information
 type FileInfo struct {
@@ -8,12 +17,44 @@ type FileInfo struct {
 	Filename string `json:"filename"`
 	Size     int64  `json:"size"`
 	Path     string `json:"path"`
+
+	// ModTime is when the file was last written
+	ModTime time.Time `json:"mod_time"`
+
+	// ContentType is the MIME type detected when the file was uploaded
+	ContentType string `json:"content_type,omitempty"`
+
+	// Hash is the hex SHA-256 of the content
+	Hash string `json:"hash,omitempty"`
+
+	// fields from the file's Metadata, see ApplyMetadata
+	Uploader    string            `json:"uploader,omitempty"`
+	UploadedAt  *time.Time        `json:"uploaded_at,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// ApplyMetadata copies the recorded metadata into the file info
+// a hash reported by the storage itself takes precedence over the recorded one
+func (fi *FileInfo) ApplyMetadata(md *Metadata) {
+	fi.ContentType = md.ContentType
+	if fi.Hash == "" {
+		fi.Hash = md.SHA256
+	}
+	fi.Uploader = md.Uploader
+	fi.UploadedAt = md.UploadedAt
+	fi.Description = md.Description
+	fi.Labels = md.Labels
 }
 
 // Storage defines the behavior for file operations
 // Implementations may be of the time local disk, or cloud storage, etc
 type Storage interface {
 	Save(path string, file io.Reader) error
+	Get(path string) (*os.File, error)
+	// Exists reports whether there is a file at path without opening it
+	Exists(path string) (bool, error)
 	ListFiles() ([]FileInfo, error)
+	ListFilesByID(id string) ([]FileInfo, error)
 	DeleteFile(path string) error
 }
@@ -0,0 +1,93 @@
+package files
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"shared/trace"
+
+	"golang.org/x/xerrors"
+)
+
+// tracedStorage records each operation of a Storage as a span of the trace in ctx
+type tracedStorage struct {
+	ctx context.Context
+	s   Storage
+}
+
+// Traced returns s recording its operations as child spans of the span in ctx,
+// s itself is returned when ctx has no span
+func Traced(ctx context.Context, s Storage) Storage {
+	if trace.SpanFrom(ctx) == nil {
+		return s
+	}
+	return &tracedStorage{ctx: ctx, s: s}
+}
+
+func (t *tracedStorage) start(op string, attrs ...trace.Attr) *trace.Span {
+	_, span := trace.Start(t.ctx, "storage."+op, trace.KindInternal, attrs...)
+	return span
+}
+
+func (t *tracedStorage) Save(path string, file io.Reader) error {
+	span := t.start("save", trace.String("file.path", path))
+	defer span.End()
+
+	err := t.s.Save(path, file)
+	span.RecordError(err)
+	return err
+}
+
+func (t *tracedStorage) Get(path string) (*os.File, error) {
+	span := t.start("get", trace.String("file.path", path))
+	defer span.End()
+
+	// a missing file is an answer rather than a failure, e.g. when checking a
+	// file exists before overwriting it
+	f, err := t.s.Get(path)
+	if !xerrors.Is(err, os.ErrNotExist) {
+		span.RecordError(err)
+	}
+	span.SetAttributes(trace.Bool("file.found", err == nil))
+	return f, err
+}
+
+func (t *tracedStorage) Exists(path string) (bool, error) {
+	span := t.start("exists", trace.String("file.path", path))
+	defer span.End()
+
+	ok, err := t.s.Exists(path)
+	span.RecordError(err)
+	span.SetAttributes(trace.Bool("file.found", ok))
+	return ok, err
+}
+
+func (t *tracedStorage) ListFiles() ([]FileInfo, error) {
+	span := t.start("list")
+	defer span.End()
+
+	fs, err := t.s.ListFiles()
+	span.RecordError(err)
+	span.SetAttributes(trace.Int("file.count", len(fs)))
+	return fs, err
+}
+
+func (t *tracedStorage) ListFilesByID(id string) ([]FileInfo, error) {
+	span := t.start("list", trace.String("file.id", id))
+	defer span.End()
+
+	fs, err := t.s.ListFilesByID(id)
+	span.RecordError(err)
+	span.SetAttributes(trace.Int("file.count", len(fs)))
+	return fs, err
+}
+
+func (t *tracedStorage) DeleteFile(path string) error {
+	span := t.start("delete", trace.String("file.path", path))
+	defer span.End()
+
+	err := t.s.DeleteFile(path)
+	span.RecordError(err)
+	return err
+}
@@ -0,0 +1,69 @@
+package files
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"shared/trace"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, spans []trace.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(ctx context.Context) error { return nil }
+
+func TestTracedStorage(t *testing.T) {
+	ls, err := NewLocalStorage(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a span the storage is used as it is
+	if Traced(context.Background(), ls) != Storage(ls) {
+		t.Fatal("expected the storage itself without a span")
+	}
+
+	rec := &spanRecorder{}
+	tr := trace.NewTracer("test", rec, nil)
+	ctx, req := tr.Start(context.Background(), "POST /images/{id}/{filename}", trace.KindServer)
+
+	s := Traced(ctx, ls)
+	if err := s.Save("1/a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("1/missing.txt"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+	if err := s.DeleteFile("../outside"); err == nil {
+		t.Fatal("expected an error deleting outside the base path")
+	}
+	req.End()
+	tr.Shutdown(context.Background())
+
+	want := map[string]trace.StatusCode{"storage.save": trace.StatusUnset, "storage.get": trace.StatusUnset, "storage.delete": trace.StatusError}
+	for _, sp := range rec.spans {
+		code, ok := want[sp.Name]
+		if !ok {
+			continue
+		}
+		delete(want, sp.Name)
+		if sp.ParentSpanID != req.Context().SpanID || sp.Status != code {
+			t.Errorf("unexpected span %+v", sp)
+		}
+	}
+	if len(want) > 0 {
+		t.Fatalf("expected spans %v, got %+v", want, rec.spans)
+	}
+}
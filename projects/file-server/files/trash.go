@@ -0,0 +1,217 @@
+package files
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrTrashItemNotFound is returned for an unknown or purged trash item
+var ErrTrashItemNotFound = xerrors.New("Trash item not found")
+
+// trashDir is the hidden directory under the base path holding deleted files
+const trashDir = ".trash"
+
+// TrashItem describes a deleted file waiting in the trash
+type TrashItem struct {
+	TrashID   string    `json:"trash_id"`
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// the file's metadata at the time it was deleted, restored with the file
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// Trash keeps deleted files for a retention period so they can be restored.
+// Each item is a directory {basePath}/.trash/{trash id} holding a copy of the
+// contents and an info.json describing where the file came from. The contents are
+// copied rather than moved so the trash works the same for every Storage
+type Trash struct {
+	basePath  string
+	retention time.Duration
+}
+
+// NewTrash creates the trash under basePath, items are kept for retention
+func NewTrash(basePath string, retention time.Duration) (*Trash, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, trashDir))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create trash directory: %w", err)
+	}
+
+	return &Trash{basePath: p, retention: retention}, nil
+}
+
+// Put moves a copy of the file at path, with its metadata, into the trash
+func (t *Trash) Put(path string, contents io.Reader, md *Metadata) (*TrashItem, error) {
+	tid, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	d := filepath.Join(t.basePath, tid)
+	err = os.MkdirAll(d, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create trash item: %w", err)
+	}
+
+	f, err := os.Create(filepath.Join(d, "data"))
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to create trash item: %w", err)
+	}
+	n, err := io.Copy(f, contents)
+	f.Close()
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to copy file to trash: %w", err)
+	}
+
+	key := filepath.ToSlash(filepath.Clean(path))
+	now := time.Now().UTC()
+	item := &TrashItem{
+		TrashID:   tid,
+		ID:        filepath.Dir(key),
+		Filename:  filepath.Base(key),
+		Path:      path,
+		Size:      n,
+		DeletedAt: now,
+		ExpiresAt: now.Add(t.retention),
+		Metadata:  md,
+	}
+
+	info, err := json.Marshal(item)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(d, "info.json"), info, 0o644)
+	}
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to write trash item: %w", err)
+	}
+
+	return item, nil
+}
+
+// Get returns a trash item
+func (t *Trash) Get(tid string) (*TrashItem, error) {
+	if !validRandomID(tid) {
+		return nil, ErrTrashItemNotFound
+	}
+
+	info, err := os.ReadFile(filepath.Join(t.basePath, tid, "info.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrTrashItemNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read trash item: %w", err)
+	}
+
+	item := &TrashItem{}
+	err = json.Unmarshal(info, item)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode trash item: %w", err)
+	}
+
+	return item, nil
+}
+
+// Open returns a trash item and its contents for reading
+// the calling function is responsible for closing the file
+func (t *Trash) Open(tid string) (*TrashItem, *os.File, error) {
+	item, err := t.Get(tid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(filepath.Join(t.basePath, tid, "data"))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Unable to open trash item: %w", err)
+	}
+
+	return item, f, nil
+}
+
+// List returns every item in the trash, most recently deleted first
+func (t *Trash) List() ([]TrashItem, error) {
+	entries, err := os.ReadDir(t.basePath)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read trash: %w", err)
+	}
+
+	items := []TrashItem{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		item, err := t.Get(e.Name())
+		if err != nil {
+			// half written or foreign directory, the purger will clean it up
+			continue
+		}
+		items = append(items, *item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+
+	return items, nil
+}
+
+// Remove permanently deletes a trash item
+func (t *Trash) Remove(tid string) error {
+	if !validRandomID(tid) {
+		return ErrTrashItemNotFound
+	}
+
+	err := os.RemoveAll(filepath.Join(t.basePath, tid))
+	if err != nil {
+		return xerrors.Errorf("Unable to remove trash item: %w", err)
+	}
+	return nil
+}
+
+// Purge permanently deletes every item which has been in the trash longer than
+// the retention period and returns how many were removed
+func (t *Trash) Purge() (int, error) {
+	entries, err := os.ReadDir(t.basePath)
+	if err != nil {
+		return 0, xerrors.Errorf("Unable to read trash: %w", err)
+	}
+
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		// items we can't read expire by the age of their directory
+		var expires time.Time
+		item, err := t.Get(e.Name())
+		if err == nil {
+			expires = item.ExpiresAt
+		} else if fi, serr := e.Info(); serr == nil {
+			expires = fi.ModTime().Add(t.retention)
+		} else {
+			continue
+		}
+
+		if time.Now().After(expires) && os.RemoveAll(filepath.Join(t.basePath, e.Name())) == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,76 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestTrashPutAndOpen(t *testing.T) {
+	tr, err := NewTrash(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := &Metadata{ContentType: "image/png", Description: "a photo"}
+	item, err := tr.Put("1/a.png", bytes.NewBufferString("hello"), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != "1" || item.Filename != "a.png" || item.Size != 5 {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	items, err := tr.List()
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d %v", len(items), err)
+	}
+
+	got, f, err := tr.Open(item.TrashID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := io.ReadAll(f)
+	f.Close()
+	if string(d) != "hello" || got.Metadata == nil || got.Metadata.Description != "a photo" {
+		t.Fatalf("unexpected contents %q %+v", d, got.Metadata)
+	}
+
+	if err := tr.Remove(item.TrashID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Get(item.TrashID); !xerrors.Is(err, ErrTrashItemNotFound) {
+		t.Fatalf("expected ErrTrashItemNotFound, got %v", err)
+	}
+
+	// ids from clients can't reach outside the trash
+	if _, err := tr.Get("../../etc"); !xerrors.Is(err, ErrTrashItemNotFound) {
+		t.Fatalf("expected ErrTrashItemNotFound, got %v", err)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	tr, err := NewTrash(t.TempDir(), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tr.Put("1/a.png", bytes.NewBufferString("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	n, err := tr.Purge()
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 purged, got %d %v", n, err)
+	}
+
+	items, _ := tr.List()
+	if len(items) != 0 {
+		t.Fatalf("expected empty trash, got %d", len(items))
+	}
+}
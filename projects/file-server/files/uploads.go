@@ -0,0 +1,339 @@
+package files
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrUploadNotFound is returned for an unknown or expired upload
+var ErrUploadNotFound = xerrors.New("Upload not found")
+
+// ErrOffsetMismatch is returned when a chunk does not start where the staged data ends
+var ErrOffsetMismatch = xerrors.New("Upload offset does not match")
+
+// ErrUploadIncomplete is returned when finalizing an upload which has not received all its bytes
+var ErrUploadIncomplete = xerrors.New("Upload is incomplete")
+
+// ErrUploadFinalized is returned for chunks or a second finalize once an upload is being saved
+var ErrUploadFinalized = xerrors.New("Upload is already finalized")
+
+// uploadsDir is the hidden directory under the base path where chunks are staged
+const uploadsDir = ".uploads"
+
+// Upload describes a resumable upload in progress
+type Upload struct {
+	UploadID  string    `json:"upload_id"`
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Uploader  string    `json:"uploader,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Uploads stages resumable uploads on disk until they are complete.
+// Each upload is a directory {basePath}/.uploads/{upload id} holding the data
+// received so far and an info.json describing it. The size of the data file
+// is the offset, so an interrupted chunk keeps whatever reached the disk
+type Uploads struct {
+	basePath    string
+	maxFileSize int64
+	expiry      time.Duration
+
+	// one lock per upload so chunks for the same upload can't interleave
+	locks KeyedMutex
+
+	// uploads which have been saved, or are being saved, until they are removed
+	mu        sync.Mutex
+	finalized map[string]bool
+}
+
+// NewUploads creates the upload staging area under basePath
+// maxSize is the max number of bytes for a file, uploads not finished within expiry are removed by Expire
+func NewUploads(basePath string, maxSize int, expiry time.Duration) (*Uploads, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, uploadsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create upload directory: %w", err)
+	}
+
+	return &Uploads{
+		basePath:    p,
+		maxFileSize: int64(maxSize),
+		expiry:      expiry,
+		finalized:   map[string]bool{},
+	}, nil
+}
+
+// Create starts a new upload of length bytes which will be stored as {id}/{filename}
+func (u *Uploads) Create(id, filename string, length int64, uploader string) (*Upload, error) {
+	if length < 0 {
+		return nil, xerrors.New("Upload length can not be negative")
+	}
+	if length > u.maxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	uid, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	up := &Upload{
+		UploadID:  uid,
+		ID:        id,
+		Filename:  filename,
+		Length:    length,
+		Uploader:  uploader,
+		CreatedAt: now,
+		ExpiresAt: now.Add(u.expiry),
+	}
+
+	d := filepath.Join(u.basePath, uid)
+	err = os.MkdirAll(d, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create upload: %w", err)
+	}
+
+	f, err := os.Create(filepath.Join(d, "data"))
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to create upload: %w", err)
+	}
+	f.Close()
+
+	info, err := json.Marshal(up)
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to encode upload: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(d, "info.json"), info, 0o644)
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, xerrors.Errorf("Unable to create upload: %w", err)
+	}
+
+	return up, nil
+}
+
+// Get returns the upload with its current offset
+func (u *Uploads) Get(uid string) (*Upload, error) {
+	if !validRandomID(uid) {
+		return nil, ErrUploadNotFound
+	}
+	if u.isFinalized(uid) {
+		return nil, ErrUploadFinalized
+	}
+
+	d := filepath.Join(u.basePath, uid)
+	info, err := os.ReadFile(filepath.Join(d, "info.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read upload: %w", err)
+	}
+
+	up := &Upload{}
+	err = json.Unmarshal(info, up)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode upload: %w", err)
+	}
+
+	// expired uploads are gone as far as clients are concerned, even before Expire runs
+	if time.Now().After(up.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+
+	fi, err := os.Stat(filepath.Join(d, "data"))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read upload: %w", err)
+	}
+	up.Offset = fi.Size()
+
+	return up, nil
+}
+
+// Append writes a chunk starting at offset and returns the new offset.
+// The chunk must start exactly where the staged data ends and may not take the
+// upload past its length. When the chunk is cut short the bytes which arrived
+// are kept, so the client can resume from the returned offset
+func (u *Uploads) Append(uid string, offset int64, chunk io.Reader) (int64, error) {
+	if !validRandomID(uid) {
+		return 0, ErrUploadNotFound
+	}
+	defer u.locks.Lock(uid)()
+
+	up, err := u.Get(uid)
+	if err != nil {
+		return 0, err
+	}
+	if offset != up.Offset {
+		return up.Offset, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(filepath.Join(u.basePath, uid, "data"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return offset, xerrors.Errorf("Unable to open upload: %w", err)
+	}
+	defer f.Close()
+
+	// never write past the declared length, one extra byte tells us the chunk was too long
+	n, err := io.Copy(f, io.LimitReader(chunk, up.Length-offset+1))
+	if n > up.Length-offset {
+		// throw the whole chunk away, the client has to send a correct one
+		f.Truncate(offset)
+		return offset, ErrFileTooLarge
+	}
+	if err != nil {
+		return offset + n, xerrors.Errorf("Unable to write chunk: %w", err)
+	}
+
+	return offset + n, nil
+}
+
+// Finalize passes the staged data of a complete upload to save.
+// The upload is locked like a chunk and marked finalized before save is called, so
+// chunks and other finalize calls are refused rather than changing the data under it.
+// When save fails the mark is dropped and the upload can be finalized again, otherwise
+// it stays finalized until the caller removes it
+func (u *Uploads) Finalize(uid string, save func(up *Upload, data io.Reader) error) (*Upload, error) {
+	if !validRandomID(uid) {
+		return nil, ErrUploadNotFound
+	}
+	defer u.locks.Lock(uid)()
+
+	up, err := u.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	if up.Offset != up.Length {
+		return up, ErrUploadIncomplete
+	}
+
+	f, err := os.Open(filepath.Join(u.basePath, uid, "data"))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to open upload: %w", err)
+	}
+	defer f.Close()
+
+	u.setFinalized(uid, true)
+	err = save(up, f)
+	if err != nil {
+		u.setFinalized(uid, false)
+		return up, err
+	}
+
+	return up, nil
+}
+
+// Remove deletes an upload and its staged data
+func (u *Uploads) Remove(uid string) error {
+	if !validRandomID(uid) {
+		return ErrUploadNotFound
+	}
+
+	defer u.locks.Lock(uid)()
+
+	err := os.RemoveAll(filepath.Join(u.basePath, uid))
+	u.setFinalized(uid, false)
+
+	if err != nil {
+		return xerrors.Errorf("Unable to remove upload: %w", err)
+	}
+	return nil
+}
+
+// Expire removes every upload which has passed its expiry time and
+// returns how many were removed
+func (u *Uploads) Expire() (int, error) {
+	entries, err := os.ReadDir(u.basePath)
+	if err != nil {
+		return 0, xerrors.Errorf("Unable to read uploads: %w", err)
+	}
+
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		// Get hides expired uploads, so read the info directly
+		info, err := os.ReadFile(filepath.Join(u.basePath, e.Name(), "info.json"))
+		up := &Upload{}
+		if err == nil {
+			err = json.Unmarshal(info, up)
+		}
+
+		// an upload without readable info can never be resumed, treat it as expired
+		// once it is older than the expiry time
+		if err != nil {
+			fi, serr := e.Info()
+			if serr != nil {
+				continue
+			}
+			up.ExpiresAt = fi.ModTime().Add(u.expiry)
+		}
+
+		if time.Now().After(up.ExpiresAt) {
+			if u.Remove(e.Name()) == nil {
+				removed++
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+func (u *Uploads) setFinalized(uid string, finalized bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if finalized {
+		u.finalized[uid] = true
+	} else {
+		delete(u.finalized, uid)
+	}
+}
+
+func (u *Uploads) isFinalized(uid string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.finalized[uid]
+}
+
+// newRandomID returns a random id for an upload or trashed file
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", xerrors.Errorf("Unable to create id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validRandomID checks an id from a client looks like one of ours, which
+// also stops it being used to reach outside our directories
+func validRandomID(uid string) bool {
+	if len(uid) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(uid)
+	return err == nil
+}
@@ -0,0 +1,161 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestUploadsResumeAndFinish(t *testing.T) {
+	u, err := NewUploads(t.TempDir(), 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up, err := u.Create("1", "a.png", 10, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := u.Append(up.UploadID, 0, bytes.NewBufferString("hello"))
+	if err != nil || n != 5 {
+		t.Fatalf("expected offset 5, got %d %v", n, err)
+	}
+
+	// a chunk for the wrong offset is refused
+	if _, err := u.Append(up.UploadID, 0, bytes.NewBufferString("hello")); !xerrors.Is(err, ErrOffsetMismatch) {
+		t.Fatalf("expected ErrOffsetMismatch, got %v", err)
+	}
+
+	// finishing early is refused
+	if _, err := u.Finalize(up.UploadID, func(*Upload, io.Reader) error { return nil }); !xerrors.Is(err, ErrUploadIncomplete) {
+		t.Fatalf("expected ErrUploadIncomplete, got %v", err)
+	}
+
+	// a chunk running past the length is discarded entirely
+	if _, err := u.Append(up.UploadID, 5, bytes.NewBufferString("world!")); !xerrors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	if _, err := u.Append(up.UploadID, 5, bytes.NewBufferString("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	var d []byte
+	_, err = u.Finalize(up.UploadID, func(_ *Upload, data io.Reader) error {
+		d, err = io.ReadAll(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != "helloworld" {
+		t.Fatalf("unexpected data %q", d)
+	}
+}
+
+func TestUploadsFinalizeLocks(t *testing.T) {
+	u, err := NewUploads(t.TempDir(), 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up, err := u.Create("1", "a.png", 5, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Append(up.UploadID, 0, bytes.NewBufferString("hello"))
+
+	// a failed save leaves the upload to be finalized again
+	_, err = u.Finalize(up.UploadID, func(*Upload, io.Reader) error { return ErrInvalidFilename })
+	if !xerrors.Is(err, ErrInvalidFilename) {
+		t.Fatalf("expected the save error, got %v", err)
+	}
+
+	saving := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := u.Finalize(up.UploadID, func(*Upload, io.Reader) error {
+			close(saving)
+			<-release
+			return nil
+		})
+		done <- err
+	}()
+	<-saving
+
+	// a chunk sent while the upload is saved waits for it, then is refused
+	appended := make(chan error)
+	go func() {
+		_, err := u.Append(up.UploadID, 5, bytes.NewBufferString("!"))
+		appended <- err
+	}()
+	select {
+	case err := <-appended:
+		t.Fatalf("expected the chunk to wait for the save, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-appended; !xerrors.Is(err, ErrUploadFinalized) {
+		t.Fatalf("expected ErrUploadFinalized, got %v", err)
+	}
+	if _, err := u.Finalize(up.UploadID, func(*Upload, io.Reader) error { return nil }); !xerrors.Is(err, ErrUploadFinalized) {
+		t.Fatalf("expected a second finalize to be refused, got %v", err)
+	}
+
+	// once removed the upload is gone
+	u.Remove(up.UploadID)
+	if _, err := u.Get(up.UploadID); !xerrors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestUploadsExpire(t *testing.T) {
+	u, err := NewUploads(t.TempDir(), 1024, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up, err := u.Create("1", "a.png", 10, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := u.Get(up.UploadID); !xerrors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected expired upload to be hidden, got %v", err)
+	}
+
+	n, err := u.Expire()
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 upload expired, got %d %v", n, err)
+	}
+}
+
+func TestUploadsUnknownLeavesNoLocks(t *testing.T) {
+	u, err := NewUploads(t.TempDir(), 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uid := range []string{"../x", "0123456789abcdef0123456789abcdef"} {
+		if _, err := u.Append(uid, 0, bytes.NewBufferString("x")); !xerrors.Is(err, ErrUploadNotFound) {
+			t.Errorf("expected ErrUploadNotFound for %s, got %v", uid, err)
+		}
+		if _, err := u.Finalize(uid, func(*Upload, io.Reader) error { return nil }); !xerrors.Is(err, ErrUploadNotFound) {
+			t.Errorf("expected ErrUploadNotFound for %s, got %v", uid, err)
+		}
+	}
+
+	if n := u.locks.len(); n != 0 {
+		t.Errorf("expected no locks to be kept, got %d", n)
+	}
+}
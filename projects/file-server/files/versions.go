@@ -0,0 +1,323 @@
+package files
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrVersionNotFound is returned for a version which was never kept or has been pruned
+var ErrVersionNotFound = xerrors.New("Version not found")
+
+// versionsDir is the hidden directory under the base path holding prior versions
+const versionsDir = ".versions"
+
+// Version describes the content a file had before it was overwritten
+type Version struct {
+	Version int    `json:"version"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256,omitempty"`
+	// ReplacedAt is when this content stopped being the current file
+	ReplacedAt time.Time `json:"replaced_at"`
+
+	// the file's metadata while this version was current
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// Versions keeps the prior contents of overwritten files as numbered versions.
+// The versions of {id}/{filename} live in {basePath}/.versions/{id}/{filename}/
+// as {n} and {n}.json, and a latest file records the highest number handed out
+// so numbers are never reused after pruning. A file which was never overwritten
+// is version 1, after N overwrites the current file is version N+1
+type Versions struct {
+	basePath string
+	max      int
+	mu       sync.Mutex
+}
+
+// NewVersions creates the version store under basePath keeping at most max
+// versions of each file
+func NewVersions(basePath string, max int) (*Versions, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, versionsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create versions directory: %w", err)
+	}
+
+	return &Versions{basePath: p, max: max}, nil
+}
+
+// Keep stores contents, the current content of the file at path, as its next version
+func (v *Versions) Keep(path string, contents io.Reader, md *Metadata) (*Version, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	err = os.MkdirAll(d, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create versions directory: %w", err)
+	}
+
+	n := v.latest(d) + 1
+	data := filepath.Join(d, strconv.Itoa(n))
+
+	f, err := os.Create(data)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create version: %w", err)
+	}
+	size, err := io.Copy(f, contents)
+	f.Close()
+	if err != nil {
+		os.Remove(data)
+		return nil, xerrors.Errorf("Unable to copy version: %w", err)
+	}
+
+	ver := &Version{Version: n, Size: size, ReplacedAt: time.Now().UTC(), Metadata: md}
+	if md != nil {
+		ver.SHA256 = md.SHA256
+	}
+
+	info, err := json.Marshal(ver)
+	if err == nil {
+		err = os.WriteFile(data+".json", info, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(d, "latest"), []byte(strconv.Itoa(n)), 0o644)
+	}
+	if err != nil {
+		os.Remove(data)
+		os.Remove(data + ".json")
+		return nil, xerrors.Errorf("Unable to write version: %w", err)
+	}
+
+	return ver, nil
+}
+
+// Discard undoes Keep when the overwrite which needed the version failed,
+// handing its number out again if no other version has been kept since
+func (v *Versions) Discard(path string, ver *Version) error {
+	d, err := v.dir(path)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	err = v.remove(d, ver.Version)
+	if err != nil {
+		return err
+	}
+
+	if v.latest(d) == ver.Version {
+		err = os.WriteFile(filepath.Join(d, "latest"), []byte(strconv.Itoa(ver.Version-1)), 0o644)
+		if err != nil {
+			return xerrors.Errorf("Unable to write version: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Current returns the version number of the current content of the file at path
+func (v *Versions) Current(path string) (int, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return 0, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.latest(d) + 1, nil
+}
+
+// List returns the kept versions of the file at path, oldest first
+func (v *Versions) List(path string) ([]Version, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.list(d)
+}
+
+// Get returns version n of the file at path
+func (v *Versions) Get(path string, n int) (*Version, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.get(d, n)
+}
+
+// Open returns version n of the file at path and its contents for reading
+// the calling function is responsible for closing the file
+func (v *Versions) Open(path string, n int) (*Version, *os.File, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ver, err := v.get(d, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(filepath.Join(d, strconv.Itoa(n)))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Unable to open version: %w", err)
+	}
+
+	return ver, f, nil
+}
+
+// Remove permanently deletes version n of the file at path
+func (v *Versions) Remove(path string, n int) error {
+	d, err := v.dir(path)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, err := v.get(d, n); err != nil {
+		return err
+	}
+
+	return v.remove(d, n)
+}
+
+// Prune removes the oldest versions of the file at path beyond the maximum
+// and returns how many were removed
+func (v *Versions) Prune(path string) (int, error) {
+	d, err := v.dir(path)
+	if err != nil {
+		return 0, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vers, err := v.list(d)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := 0; i < len(vers)-v.max; i++ {
+		err = v.remove(d, vers[i].Version)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// dir returns the directory holding the versions of path, refusing paths
+// which are not {id}/{filename}
+func (v *Versions) dir(path string) (string, error) {
+	key := filepath.ToSlash(filepath.Clean(path))
+	id, fn := filepath.Dir(key), filepath.Base(key)
+
+	if !validID(id) {
+		return "", ErrVersionNotFound
+	}
+	if cfn, err := CleanFilename(fn); err != nil || cfn != fn {
+		return "", ErrVersionNotFound
+	}
+
+	return filepath.Join(v.basePath, id, fn), nil
+}
+
+// latest reads the highest version number handed out in d
+func (v *Versions) latest(d string) int {
+	b, err := os.ReadFile(filepath.Join(d, "latest"))
+	if err != nil {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(string(b))
+	return n
+}
+
+func (v *Versions) list(d string) ([]Version, error) {
+	entries, err := os.ReadDir(d)
+	if os.IsNotExist(err) {
+		return []Version{}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read versions: %w", err)
+	}
+
+	vers := []Version{}
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		ver, err := v.get(d, n)
+		if err != nil {
+			continue
+		}
+		vers = append(vers, *ver)
+	}
+
+	sort.Slice(vers, func(i, j int) bool { return vers[i].Version < vers[j].Version })
+
+	return vers, nil
+}
+
+func (v *Versions) get(d string, n int) (*Version, error) {
+	info, err := os.ReadFile(filepath.Join(d, strconv.Itoa(n)+".json"))
+	if os.IsNotExist(err) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read version: %w", err)
+	}
+
+	ver := &Version{}
+	err = json.Unmarshal(info, ver)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode version: %w", err)
+	}
+
+	return ver, nil
+}
+
+func (v *Versions) remove(d string, n int) error {
+	p := filepath.Join(d, strconv.Itoa(n))
+
+	for _, fp := range []string{p + ".json", p} {
+		err := os.Remove(fp)
+		if err != nil && !os.IsNotExist(err) {
+			return xerrors.Errorf("Unable to remove version: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestVersionsKeepAndPrune(t *testing.T) {
+	v, err := NewVersions(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{"one", "two", "three"} {
+		if _, err := v.Keep("1/a.png", bytes.NewBufferString(c), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := v.Current("1/a.png"); err != nil || n != 4 {
+		t.Fatalf("expected current version 4, got %d %v", n, err)
+	}
+
+	n, err := v.Prune("1/a.png")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 pruned, got %d %v", n, err)
+	}
+
+	vers, err := v.List("1/a.png")
+	if err != nil || len(vers) != 2 || vers[0].Version != 2 || vers[1].Version != 3 {
+		t.Fatalf("expected versions 2 and 3, got %+v %v", vers, err)
+	}
+
+	if _, _, err := v.Open("1/a.png", 1); !xerrors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("expected ErrVersionNotFound, got %v", err)
+	}
+
+	_, f, err := v.Open("1/a.png", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := io.ReadAll(f)
+	f.Close()
+	if string(d) != "three" {
+		t.Fatalf("unexpected contents %q", d)
+	}
+
+	// numbers aren't reused once the oldest versions are gone
+	ver, err := v.Keep("1/a.png", bytes.NewBufferString("four"), nil)
+	if err != nil || ver.Version != 4 {
+		t.Fatalf("expected version 4, got %+v %v", ver, err)
+	}
+}
+
+func TestVersionsDiscard(t *testing.T) {
+	v, err := NewVersions(t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ver, err := v.Keep("1/a.png", bytes.NewBufferString("one"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Discard("1/a.png", ver); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := v.Current("1/a.png"); n != 1 {
+		t.Fatalf("expected current version 1 after discard, got %d", n)
+	}
+}
+
+func TestVersionsRejectsBadPaths(t *testing.T) {
+	v, err := NewVersions(t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"a.png", "../a.png", "1/.hidden", "1/2/a.png"} {
+		if _, err := v.List(p); !xerrors.Is(err, ErrVersionNotFound) {
+			t.Errorf("%s: expected ErrVersionNotFound, got %v", p, err)
+		}
+	}
+}
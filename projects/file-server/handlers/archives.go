@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"file-server/files"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// defaultMaxArchiveSize bounds the size of an uploaded archive unless WithMaxArchiveSize
+// is used, a zip has to be spooled to a temporary file because zip keeps its directory
+// at the end of the archive
+const defaultMaxArchiveSize = 100 * 1024 * 1024
+
+// WithMaxArchiveSize sets the largest archive which can be uploaded in one request
+func (f *Files) WithMaxArchiveSize(n int64) *Files {
+	f.maxArchiveSize = n
+	return f
+}
+
+// DownloadArchive streams every file of a product id as a zip or tar.gz archive
+// the archive is written straight to the response, nothing is buffered on disk
+func (f *Files) DownloadArchive(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	format := vars["format"]
+
+	f.logger(r.Context()).Info("Handle GET archive", "id", id, "format", format)
+
+	fs, err := f.storage(r.Context()).ListFilesByID(id)
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to list files", "id", id, "error", err)
+		http.Error(rw, "Unable to list files", http.StatusInternalServerError)
+		return
+	}
+	if len(fs) == 0 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+id+"."+format+`"`)
+	if format == "zip" {
+		rw.Header().Set("Content-Type", "application/zip")
+		err = files.WriteZip(rw, f.storage(r.Context()), fs)
+	} else {
+		rw.Header().Set("Content-Type", "application/gzip")
+		err = files.WriteTarGz(rw, f.storage(r.Context()), fs)
+	}
+
+	// the headers are already sent, all we can do is log and cut the response short
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to write archive", "id", id, "error", err)
+	}
+}
+
+// UploadArchive saves every file in an uploaded zip, tar or tar.gz archive under the id.
+// Entry names go through the same filename checks as multipart uploads and
+// each file is validated like a direct upload. Entries are saved as they are read,
+// when one fails the error lists the files saved before it like a multipart upload
+func (f *Files) UploadArchive(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	format := vars["format"]
+	by := uploader(r)
+
+	f.logger(r.Context()).Info("Handle POST archive", "id", id, "format", format)
+
+	saved := []files.FileInfo{}
+	seen := map[string]bool{}
+	failed := ""
+	save := func(name string, contents io.Reader) error {
+		fn, err := files.CleanFilename(name)
+		if err != nil {
+			return xerrors.Errorf("%q: %w", name, err)
+		}
+
+		// entries are stored by base name, a second one would replace the first
+		if seen[fn] {
+			return xerrors.Errorf("%q: %w", name, files.ErrDuplicateEntry)
+		}
+		seen[fn] = true
+
+		fi, err := f.save(r.Context(), id, fn, contents, http.Header{}, by)
+		if err != nil {
+			failed = fn
+			return err
+		}
+		saved = append(saved, *fi)
+		return nil
+	}
+
+	body := http.MaxBytesReader(rw, r.Body, f.maxArchiveSize)
+
+	var err error
+	switch format {
+	case "zip":
+		err = f.readZip(body, save)
+	case "tar":
+		err = files.ReadTar(body, false, save)
+	default:
+		err = files.ReadTar(body, true, save)
+	}
+
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to extract archive", "id", id, "saved", len(saved), "error", err)
+		switch {
+		case xerrors.Is(err, files.ErrInvalidFilename), xerrors.Is(err, files.ErrInvalidArchive),
+			xerrors.Is(err, files.ErrTooManyEntries), xerrors.Is(err, files.ErrDuplicateEntry):
+			multipartError(rw, http.StatusBadRequest, err.Error(), saved)
+		case len(saved) == 0:
+			f.saveError(rw, err)
+		default:
+			status, msg := saveStatus(err)
+			if failed != "" {
+				msg = fmt.Sprintf("Unable to save %s: %s", failed, msg)
+			}
+			multipartError(rw, status, msg, saved)
+		}
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(saved)
+}
+
+// readZip spools the request body to a temporary file so it can be read as a zip
+func (f *Files) readZip(body io.Reader, fn func(string, io.Reader) error) error {
+	tmp, err := os.CreateTemp("", "file-server-*.zip")
+	if err != nil {
+		return xerrors.Errorf("Unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(body, f.maxArchiveSize+1))
+	if err != nil {
+		return xerrors.Errorf("Unable to read archive: %w", err)
+	}
+	if n > f.maxArchiveSize {
+		return files.ErrFileTooLarge
+	}
+
+	return files.ReadZip(tmp, n, fn)
+}
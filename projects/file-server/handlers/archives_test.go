@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"file-server/files"
+)
+
+// zipBody builds a zip archive from name and contents pairs
+func zipBody(t *testing.T, entries ...string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entries[i+1]))
+	}
+	zw.Close()
+	return &buf
+}
+
+// tarBody builds a tar archive from name and contents pairs
+func tarBody(t *testing.T, entries ...string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		err := tw.WriteHeader(&tar.Header{Name: entries[i], Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(entries[i+1]))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(entries[i+1]))
+	}
+	tw.Close()
+	return &buf
+}
+
+// partialResponse is the error body of an upload which failed part way
+type partialResponse struct {
+	Message string           `json:"message"`
+	Saved   []files.FileInfo `json:"saved"`
+}
+
+func TestUploadArchiveCleansPaths(t *testing.T) {
+	f, base := newTestFiles(t, "image/png")
+	png := string(pngHeader)
+
+	body := zipBody(t, "photos/a.png", png, "../x.png", png, "/abs.png", png)
+	rw := serve(newRouter(f), http.MethodPost, "/images/1.zip", body)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+
+	var saved []files.FileInfo
+	json.NewDecoder(rw.Body).Decode(&saved)
+	if len(saved) != 3 {
+		t.Fatalf("expected 3 saved files, got %+v", saved)
+	}
+
+	// every entry lands in the id directory by its base name
+	for _, fn := range []string{"a.png", "x.png", "abs.png"} {
+		if _, err := os.Stat(filepath.Join(base, "1", fn)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(base), "x.png")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing outside the base path, got %v", err)
+	}
+}
+
+func TestUploadArchiveRejectsEntries(t *testing.T) {
+	png := string(pngHeader)
+
+	tests := []struct {
+		name   string
+		body   *bytes.Buffer
+		format string
+		status int
+		saved  int
+	}{
+		{"hidden", zipBody(t, ".hidden", png), "zip", http.StatusBadRequest, 0},
+		{"duplicate", zipBody(t, "a.png", png, "photos/a.png", png), "zip", http.StatusBadRequest, 1},
+		{"duplicate tar", tarBody(t, "a.png", png, "b.png", png, "x/b.png", png), "tar", http.StatusBadRequest, 2},
+		{"unsupported type", tarBody(t, "a.png", png, "b.png", "plain text"), "tar", http.StatusUnsupportedMediaType, 1},
+		{"invalid", bytes.NewBufferString("not an archive"), "zip", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newTestFiles(t, "image/png")
+
+			rw := serve(newRouter(f), http.MethodPost, "/images/1."+tc.format, tc.body)
+			if rw.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, rw.Code, rw.Body)
+			}
+
+			// the files saved before the failure are listed
+			if tc.saved > 0 {
+				var resp partialResponse
+				err := json.NewDecoder(rw.Body).Decode(&resp)
+				if err != nil || len(resp.Saved) != tc.saved || resp.Message == "" {
+					t.Errorf("expected %d saved files, got %+v %v", tc.saved, resp, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadArchiveSizeLimit(t *testing.T) {
+	png := string(pngHeader)
+
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			f, _ := newTestFiles(t, "image/png")
+			f.WithMaxArchiveSize(64)
+
+			body := zipBody(t, "a.png", png+string(make([]byte, 1024)))
+			if format == "tar" {
+				body = tarBody(t, "a.png", png+string(make([]byte, 1024)))
+			}
+
+			rw := serve(newRouter(f), http.MethodPost, "/images/1."+format, body)
+			if rw.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("expected 413, got %d: %s", rw.Code, rw.Body)
+			}
+		})
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	png := string(pngHeader)
+
+	for _, format := range []string{"zip", "tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			f, _ := newTestFiles(t, "image/png")
+			r := newRouter(f)
+
+			if rw := serve(r, http.MethodGet, "/images/1."+format, nil); rw.Code != http.StatusNotFound {
+				t.Fatalf("expected 404 for an id without files, got %d", rw.Code)
+			}
+
+			for _, fn := range []string{"a.png", "b.png"} {
+				rw := serve(r, http.MethodPost, "/images/1/"+fn, bytes.NewBufferString(png))
+				if rw.Code >= 300 {
+					t.Fatalf("unable to upload %s: %d %s", fn, rw.Code, rw.Body)
+				}
+			}
+
+			rw := serve(r, http.MethodGet, "/images/1."+format, nil)
+			if rw.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rw.Code)
+			}
+			archive := rw.Body.Bytes()
+
+			// the downloaded archive uploads back to another id unchanged
+			rw = serve(r, http.MethodPost, "/images/2."+format, bytes.NewReader(archive))
+			if rw.Code != http.StatusCreated {
+				t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+			}
+
+			for _, fn := range []string{"a.png", "b.png"} {
+				rw := serve(r, http.MethodGet, "/images/2/"+fn, nil)
+				d, _ := io.ReadAll(rw.Body)
+				if rw.Code != http.StatusOK || string(d) != png {
+					t.Errorf("unexpected %s after round trip: %d %q", fn, rw.Code, d)
+				}
+			}
+		})
+	}
+}
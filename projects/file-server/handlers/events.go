@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"file-server/events"
+	"file-server/files"
+)
+
+// sseHeartbeat is how often an idle event stream sends a comment so proxies
+// don't close the connection
+const sseHeartbeat = 30 * time.Second
+
+// WithEvents publishes file changes to the Server-Sent Events stream s and,
+// when w is not nil, to webhooks
+func (f *Files) WithEvents(s *events.Stream, w *events.Webhooks) *Files {
+	f.stream = s
+	f.webhooks = w
+	return f
+}
+
+// publishSave publishes the event for a file which was uploaded or, when
+// replaced is true, overwritten
+func (f *Files) publishSave(replaced bool, fi *files.FileInfo) {
+	if replaced {
+		f.publish(events.FileOverwritten, fi)
+		return
+	}
+	f.publish(events.FileUploaded, fi)
+}
+
+// publish sends an event of type typ for fi to the stream and webhooks
+func (f *Files) publish(typ string, fi *files.FileInfo) {
+	if f.stream == nil && f.webhooks == nil {
+		return
+	}
+
+	e, err := events.New(typ, *fi)
+	if err != nil {
+		f.log.Error("Unable to create event", "type", typ, "path", fi.Path, "error", err)
+		return
+	}
+
+	if f.stream != nil {
+		f.stream.Publish(e)
+	}
+	if f.webhooks != nil {
+		f.webhooks.Publish(e)
+	}
+}
+
+// Events streams file changes as Server-Sent Events, ?id= limits the stream
+// to one product id
+func (f *Files) Events(rw http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	f.logger(r.Context()).Info("Handle GET events", "id", id)
+
+	// the stream outlives the server's write timeout
+	rc := http.NewResponseController(rw)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to clear write deadline for event stream", "error", err)
+		http.Error(rw, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, cancel := f.stream.Subscribe()
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	hb := time.NewTicker(sseHeartbeat)
+	defer hb.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-hb.C:
+			_, err = fmt.Fprint(rw, ": heartbeat\n\n")
+		case e, ok := <-ch:
+			if !ok {
+				// the server is shutting down
+				return
+			}
+			if id != "" && e.File.ID != id {
+				continue
+			}
+
+			data, _ := json.Marshal(e)
+			_, err = fmt.Fprintf(rw, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
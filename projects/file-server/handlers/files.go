@@ -1,25 +1,90 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"time"
 
+	"file-server/events"
 	"file-server/files"
+	"file-server/images"
+	"file-server/products"
+	"shared/accesslog"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"golang.org/x/xerrors"
 )
 
 // Files is a handler for reading and writing files
 type Files struct {
 	log   hclog.Logger
 	store files.Storage
+	meta  *files.MetadataStore
+	types *files.TypeChecker
+
+	renditions   *images.Cache
+	maxDimension int
+
+	uploads  *files.Uploads
+	quotas   *files.QuotaStorage
+	trash    *files.Trash
+	versions *files.Versions
+
+	stream   *events.Stream
+	webhooks *events.Webhooks
+
+	products products.Client
+
+	maxArchiveSize int64
+
+	// one lock per file so its content and metadata change together
+	paths files.KeyedMutex
 }
 
 // NewFiles creates a new File handler
-func NewFiles(s files.Storage, l hclog.Logger) *Files {
-	return &Files{store: s, log: l}
+// uploads are validated with the TypeChecker and their detected type recorded in the MetadataStore
+func NewFiles(s files.Storage, m *files.MetadataStore, tc *files.TypeChecker, l hclog.Logger) *Files {
+	return &Files{store: s, meta: m, types: tc, log: l, maxArchiveSize: defaultMaxArchiveSize}
+}
+
+// lockPath locks the file at fp, call the returned func to unlock it
+func (f *Files) lockPath(fp string) func() {
+	return f.paths.Lock(filepath.ToSlash(filepath.Clean(fp)))
+}
+
+// storage returns the storage recording its operations as spans of the
+// request's trace in ctx
+func (f *Files) storage(ctx context.Context) files.Storage {
+	return files.Traced(ctx, f.store)
+}
+
+// logger returns the logger for the request in ctx, tagged with its request id
+func (f *Files) logger(ctx context.Context) hclog.Logger {
+	return requestLogger(f.log, ctx)
+}
+
+// requestLogger adds the id of the request in ctx to l
+func requestLogger(l hclog.Logger, ctx context.Context) hclog.Logger {
+	if id := accesslog.RequestID(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
+
+// WithRenditions enables resizing on download, derived images are cached in c
+// and may be at most maxDimension pixels wide or high
+func (f *Files) WithRenditions(c *images.Cache, maxDimension int) *Files {
+	f.renditions = c
+	f.maxDimension = maxDimension
+	return f
 }
 
 // ServeHTTP implements the http.Handler interface
@@ -28,7 +93,7 @@ func (f *Files) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	fn := vars["filename"]
 
-	f.log.Info("Handle POST", "id", id, "filename", fn)
+	f.logger(r.Context()).Info("Handle POST", "id", id, "filename", fn)
 
 	// no need to check for invalid id or filename as the mux router will not send requests
 	// here unless they have the correct parameters
@@ -36,24 +101,234 @@ func (f *Files) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	f.saveFile(id, fn, rw, r)
 }
 
-// ListFiles returns a list of all files in the storage
+// UploadMultipart handles multipart/form-data uploads for a product id
+// every file part in the form is saved under the id using the part's filename,
+// parts are streamed straight into storage so large uploads are never held in memory.
+// Parts are saved as they arrive, so when a part fails the files saved before it
+// are kept and listed in the saved field of the error response
+func (f *Files) UploadMultipart(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	f.logger(r.Context()).Info("Handle POST multipart", "id", id)
+
+	// MultipartReader gives us the parts one at a time instead of ParseMultipartForm
+	// which would buffer the whole form in memory or temp files
+	mr, err := r.MultipartReader()
+	if err != nil {
+		f.logger(r.Context()).Error("Bad multipart request", "error", err)
+		http.Error(rw, "Expected multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+
+	saved := []files.FileInfo{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.logger(r.Context()).Error("Unable to read multipart body", "error", err)
+			multipartError(rw, http.StatusBadRequest, "Unable to read multipart body", saved)
+			return
+		}
+
+		// plain form fields have no filename, we only care about files
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		fn, err := files.CleanFilename(part.FileName())
+		if err != nil {
+			part.Close()
+			f.logger(r.Context()).Error("Invalid filename in multipart upload", "filename", part.FileName())
+			multipartError(rw, http.StatusBadRequest, "Invalid filename: "+part.FileName(), saved)
+			return
+		}
+
+		fi, err := f.save(r.Context(), id, fn, part, http.Header(part.Header), uploader(r))
+		part.Close()
+		if err != nil {
+			f.logger(r.Context()).Error("Unable to save file", "filename", fn, "error", err)
+			if len(saved) == 0 {
+				f.saveError(rw, err)
+				return
+			}
+			status, msg := saveStatus(err)
+			multipartError(rw, status, fmt.Sprintf("Unable to save %s: %s", fn, msg), saved)
+			return
+		}
+
+		f.logger(r.Context()).Info("Saved multipart file", "id", id, "filename", fn, "size", fi.Size, "content_type", fi.ContentType)
+		saved = append(saved, *fi)
+	}
+
+	if len(saved) == 0 {
+		http.Error(rw, "No files found in multipart request", http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(saved)
+}
+
+// GetFile serves the file for a product id, when the query contains w, h, fit or format
+// a resized rendition is served instead of the original
+func (f *Files) GetFile(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+	fp := filepath.Join(id, fn)
+
+	f.logger(r.Context()).Debug("Handle GET", "id", id, "filename", fn)
+
+	// an older version of the file
+	if f.versions != nil && r.URL.Query().Has("version") && f.getVersion(rw, r, fp) {
+		return
+	}
+
+	src, err := f.storage(r.Context()).Get(fp)
+	if err != nil {
+		f.logger(r.Context()).Debug("File not found", "path", fp, "error", err)
+		http.NotFound(rw, r)
+		return
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to stat file", "path", fp, "error", err)
+		http.Error(rw, "Unable to read file", http.StatusInternalServerError)
+		return
+	}
+
+	// no resize parameters, serve the original
+	// ServeContent handles Range, If-Modified-Since and sets the Content-Type from the name
+	if f.renditions == nil || !images.HasOptions(r.URL.Query()) {
+		// let the client verify what it downloaded
+		md, err := f.meta.Get(fp)
+		if err == nil && md.SHA256 != "" {
+			rw.Header().Set("Digest", files.DigestHeader(md.SHA256))
+		}
+
+		http.ServeContent(rw, r, fn, fi.ModTime(), src)
+		return
+	}
+
+	o, err := images.ParseOptions(r.URL.Query(), f.maxDimension)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// use the cached rendition if it is newer than the original
+	rf, err := f.renditions.Get(fp, o.Key(), fi.ModTime())
+	if err != nil {
+		f.logger(r.Context()).Debug("Generating rendition", "path", fp, "key", o.Key())
+
+		rf, err = f.renditions.Put(fp, o.Key(), func(w io.Writer) error {
+			_, err := images.Transform(src, o, w)
+			return err
+		})
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to resize image", "path", fp, "error", err)
+		switch {
+		case xerrors.Is(err, images.ErrUnsupportedImage):
+			http.Error(rw, "Image format can not be resized", http.StatusUnsupportedMediaType)
+		case xerrors.Is(err, images.ErrImageTooLarge):
+			http.Error(rw, "Image is too large to resize", http.StatusUnprocessableEntity)
+		default:
+			http.Error(rw, "Unable to resize image", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer rf.Close()
+
+	rfi, err := rf.Stat()
+	if err != nil {
+		http.Error(rw, "Unable to read file", http.StatusInternalServerError)
+		return
+	}
+
+	// the rendition key has no extension so ServeContent sniffs the type from the image bytes
+	http.ServeContent(rw, r, "", rfi.ModTime(), rf)
+}
+
+// ListFiles returns a list of the files in the storage
+// the listing can be filtered, sorted and paged with the query parameters described
+// by files.ParseListQuery, when there are more results the X-Next-Cursor header
+// holds the cursor for the next page
 func (f *Files) ListFiles(rw http.ResponseWriter, r *http.Request) {
-	f.log.Info("Handle GET /files - listing all files")
+	f.logger(r.Context()).Info("Handle GET /files - listing files", "query", r.URL.RawQuery)
+
+	f.listFiles(rw, r, r.URL.Query())
+}
 
-	files, err := f.store.ListFiles()
+// ListProductFiles returns the files stored for one product id, it accepts
+// the same query parameters as ListFiles
+func (f *Files) ListProductFiles(rw http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	f.logger(r.Context()).Info("Handle GET /images/{id} - listing files", "id", id)
+
+	q := r.URL.Query()
+	q.Set("id", id)
+	f.listFiles(rw, r, q)
+}
+
+func (f *Files) listFiles(rw http.ResponseWriter, r *http.Request, q url.Values) {
+	lq, err := files.ParseListQuery(q)
 	if err != nil {
-		f.log.Error("Unable to list files", "error", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// only read the one id directory when we can
+	var fs []files.FileInfo
+	if lq.ID != "" {
+		fs, err = f.storage(r.Context()).ListFilesByID(lq.ID)
+	} else {
+		fs, err = f.storage(r.Context()).ListFiles()
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to list files", "error", err)
 		http.Error(rw, "Unable to list files", http.StatusInternalServerError)
 		return
 	}
 
+	page, next, err := lq.Apply(fs)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// add the recorded metadata, files stored before metadata
+	// was recorded simply have none
+	for i := range page {
+		md, err := f.meta.Get(page[i].Path)
+		if err == nil {
+			page[i].ApplyMetadata(md)
+		}
+	}
+
+	if next != "" {
+		rw.Header().Set("X-Next-Cursor", next)
+
+		nq := r.URL.Query()
+		nq.Set("cursor", next)
+		rw.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, nq.Encode()))
+	}
+
 	// Set content type to JSON
 	rw.Header().Set("Content-Type", "application/json")
 
 	// Encode and send the response
-	err = json.NewEncoder(rw).Encode(files)
+	err = json.NewEncoder(rw).Encode(page)
 	if err != nil {
-		f.log.Error("Unable to encode response", "error", err)
+		f.logger(r.Context()).Error("Unable to encode response", "error", err)
 		http.Error(rw, "Unable to encode response", http.StatusInternalServerError)
 		return
 	}
@@ -65,37 +340,241 @@ func (f *Files) DeleteFile(rw http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	fn := vars["filename"]
 
-	f.log.Info("Handle DELETE", "id", id, "filename", fn)
+	f.logger(r.Context()).Info("Handle DELETE", "id", id, "filename", fn)
+
+	// only names an upload could have created, so ".." or ".meta" can't reach outside the file
+	if cleaned, err := files.CleanFilename(fn); err != nil || cleaned != fn {
+		f.logger(r.Context()).Error("Invalid filename", "filename", fn)
+		http.Error(rw, "Invalid filename", http.StatusBadRequest)
+		return
+	}
 
 	// Construct the file path
 	filePath := filepath.Join(id, fn)
 
+	unlock := f.lockPath(filePath)
+	defer unlock()
+
+	// keep a copy in the trash first so the delete can be undone
+	var item *files.TrashItem
+	if f.trash != nil {
+		var err error
+		item, err = f.moveToTrash(r.Context(), filePath)
+		if xerrors.Is(err, os.ErrNotExist) {
+			http.Error(rw, "File not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			f.logger(r.Context()).Error("Unable to move file to trash", "error", err)
+			http.Error(rw, "Unable to delete file", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Delete the file
-	err := f.store.DeleteFile(filePath)
+	err := f.storage(r.Context()).DeleteFile(filePath)
 	if err != nil {
-		f.log.Error("Unable to delete file", "error", err)
+		f.logger(r.Context()).Error("Unable to delete file", "error", err)
+		if item != nil {
+			f.trash.Remove(item.TrashID)
+		}
 		http.Error(rw, "Unable to delete file", http.StatusInternalServerError)
 		return
 	}
 
+	// the file is gone, a stale sidecar is only noise so just log failures
+	err = f.meta.Delete(filePath)
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to delete metadata", "error", err)
+	}
+	f.purgeRenditions(filePath)
+
+	fi := &files.FileInfo{ID: id, Filename: fn, Path: filePath}
+	if item != nil {
+		fi.Size = item.Size
+	}
+	f.publish(events.FileDeleted, fi)
+
 	// Return success response
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	json.NewEncoder(rw).Encode(map[string]string{
+	resp := map[string]string{
 		"message":  "File deleted successfully",
 		"id":       id,
 		"filename": fn,
-	})
+	}
+	if item != nil {
+		resp["message"] = "File moved to trash"
+		resp["trash_id"] = item.TrashID
+	}
+	json.NewEncoder(rw).Encode(resp)
 }
 
 // saveFile saves the contents of the request to a file
 func (f *Files) saveFile(id, path string, rw http.ResponseWriter, r *http.Request) {
-	f.log.Info("Save file for product", "id", id, "path", path)
+	f.logger(r.Context()).Info("Save file for product", "id", id, "path", path)
+
+	fn, err := files.CleanFilename(path)
+	if err != nil || fn != path {
+		f.logger(r.Context()).Error("Invalid filename", "path", path)
+		http.Error(rw, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	_, err = f.save(r.Context(), id, fn, r.Body, r.Header, uploader(r))
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to save file", "error", err)
+		f.saveError(rw, err)
+	}
+}
+
+// save validates the contents type, writes them to storage under {id}/{filename}
+// and records the detected content type, checksum and who uploaded it. When the headers
+// carry a Content-MD5 or Digest the contents are verified against it before they are stored
+func (f *Files) save(ctx context.Context, id, fn string, contents io.Reader, h http.Header, by string) (*files.FileInfo, error) {
+	digests, err := files.ParseDigests(h)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, body, err := f.types.Check(fn, contents)
+	if err != nil {
+		return nil, err
+	}
 
-	fp := filepath.Join(id, path)
-	err := f.store.Save(fp, r.Body)
+	fp := filepath.Join(id, fn)
+	cs := files.NewChecksumReader(body, digests)
+	cr := &countingReader{r: cs}
+
+	// the content and its metadata are written together, so a concurrent upload
+	// of the same file can't leave its checksum next to our content
+	unlock := f.lockPath(fp)
+	defer unlock()
+
+	replaced, err := f.overwrite(ctx, fp, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	// description and labels survive the file being overwritten
+	now := time.Now().UTC()
+	md, err := f.meta.Update(fp, func(md *files.Metadata) error {
+		md.ContentType = ct
+		md.SHA256 = cs.SHA256()
+		md.Uploader = by
+		md.UploadedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.purgeRenditions(fp)
+
+	fi := &files.FileInfo{
+		ID:       id,
+		Filename: fn,
+		Size:     cr.n,
+		Path:     fp,
+		ModTime:  now,
+	}
+	fi.ApplyMetadata(md)
+	f.publishSave(replaced, fi)
+
+	return fi, nil
+}
+
+// purgeRenditions drops the cached renditions of a file which changed or was removed
+func (f *Files) purgeRenditions(fp string) {
+	if f.renditions == nil {
+		return
+	}
+
+	err := f.renditions.Purge(fp)
+	if err != nil {
+		f.log.Error("Unable to purge renditions", "path", fp, "error", err)
+	}
+}
+
+// saveError writes the http error matching a failed save
+func (f *Files) saveError(rw http.ResponseWriter, err error) {
+	status, msg := saveStatus(err)
+
+	var qe *files.QuotaError
+	if xerrors.As(err, &qe) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(struct {
+			Message string `json:"message"`
+			*files.QuotaError
+		}{msg, qe})
+		return
+	}
+	http.Error(rw, msg, status)
+}
+
+// saveStatus returns the status and message of the response to a failed save
+func saveStatus(err error) (int, string) {
+	var mbe *http.MaxBytesError
+	var qe *files.QuotaError
+	switch {
+	case xerrors.As(err, &qe):
+		// the id is over its allowance: 413, the whole store is full: 507
+		if qe.Scope == files.QuotaScopeGlobal {
+			return http.StatusInsufficientStorage, qe.Error()
+		}
+		return http.StatusRequestEntityTooLarge, qe.Error()
+	case xerrors.Is(err, files.ErrFileTooLarge), xerrors.As(err, &mbe):
+		return http.StatusRequestEntityTooLarge, "File exceeds the maximum allowed size"
+	case xerrors.Is(err, files.ErrUnsupportedType), xerrors.Is(err, files.ErrExtensionMismatch):
+		return http.StatusUnsupportedMediaType, err.Error()
+	case xerrors.Is(err, files.ErrChecksumMismatch):
+		return http.StatusBadRequest, "Uploaded content does not match the supplied digest"
+	case xerrors.Is(err, files.ErrInvalidDigest):
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, "Unable to save file"
+}
+
+// multipartError writes the error of a multipart upload which failed part way,
+// listing the files saved before the failure. Without saved files it is a plain
+// http error like any other upload's
+func multipartError(rw http.ResponseWriter, status int, msg string, saved []files.FileInfo) {
+	if len(saved) == 0 {
+		http.Error(rw, msg, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(struct {
+		Message string           `json:"message"`
+		Saved   []files.FileInfo `json:"saved"`
+	}{msg, saved})
+}
+
+// uploader identifies who uploaded a file, clients may name themselves
+// with the X-Uploader header otherwise the remote address is recorded
+func uploader(r *http.Request) string {
+	if u := r.Header.Get("X-Uploader"); u != "" {
+		return u
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		f.log.Error("Unable to save file", "error", err)
-		http.Error(rw, "Unable to save file", http.StatusInternalServerError)
+		return r.RemoteAddr
 	}
+	return host
+}
+
+// countingReader counts the bytes read through it so we can report file sizes
+// without a second stat of the stored file
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"file-server/files"
+	"file-server/images"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// newTestFiles creates a Files handler storing in a temporary directory, the
+// base path of the storage is returned with it. allowed lists the accepted
+// content types, none accepts any
+func newTestFiles(t *testing.T, allowed ...string) (*Files, string) {
+	t.Helper()
+	base := t.TempDir()
+
+	st, err := files.NewLocalStorage(base, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := files.NewMetadataStore(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFiles(st, meta, files.NewTypeChecker(allowed), hclog.NewNullLogger()), base
+}
+
+// multipartBody builds a form with a file part per name and contents pair
+func multipartBody(t *testing.T, parts ...string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i < len(parts); i += 2 {
+		w, err := mw.CreateFormFile("file", parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(parts[i+1]))
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestUploadMultipart(t *testing.T) {
+	f, base := newTestFiles(t, "image/png")
+	r := newRouter(f)
+
+	body, ct := multipartBody(t, "a.png", string(pngHeader), "b.png", string(pngHeader))
+	rw := serve(r, http.MethodPost, "/images/1", body, "Content-Type", ct)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+
+	var saved []files.FileInfo
+	json.NewDecoder(rw.Body).Decode(&saved)
+	if len(saved) != 2 || saved[0].Filename != "a.png" || saved[1].Filename != "b.png" {
+		t.Errorf("unexpected saved files %+v", saved)
+	}
+	if _, err := os.Stat(filepath.Join(base, "1", "b.png")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadMultipartPartialFailure(t *testing.T) {
+	f, base := newTestFiles(t, "image/png")
+	r := newRouter(f)
+
+	// the second part is text, rejected after the first was saved
+	body, ct := multipartBody(t, "a.png", string(pngHeader), "b.png", "plain text", "c.png", string(pngHeader))
+	rw := serve(r, http.MethodPost, "/images/1", body, "Content-Type", ct)
+	if rw.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d: %s", rw.Code, rw.Body)
+	}
+
+	var resp struct {
+		Message string           `json:"message"`
+		Saved   []files.FileInfo `json:"saved"`
+	}
+	err := json.NewDecoder(rw.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Saved) != 1 || resp.Saved[0].Filename != "a.png" || resp.Message == "" {
+		t.Errorf("expected a.png to be reported as saved, got %+v", resp)
+	}
+
+	// the file saved before the failure is kept, those after it are not read
+	if _, err := os.Stat(filepath.Join(base, "1", "a.png")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "1", "c.png")); !os.IsNotExist(err) {
+		t.Errorf("expected c.png not to be saved, got %v", err)
+	}
+}
+
+func TestUploadMultipartFirstPartFails(t *testing.T) {
+	f, _ := newTestFiles(t, "image/png")
+
+	body, ct := multipartBody(t, "a.png", "plain text")
+	rw := serve(newRouter(f), http.MethodPost, "/images/1", body, "Content-Type", ct)
+	if rw.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rw.Code)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct == "application/json" {
+		t.Errorf("expected a plain error without saved files, got %s", ct)
+	}
+}
+
+func TestConcurrentUploadsKeepMetadataWithContent(t *testing.T) {
+	f, base := newTestFiles(t, "image/png")
+	r := newRouter(f)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			serve(r, http.MethodPost, "/images/1/a.png", bytes.NewBufferString(fmt.Sprintf("%s%d", pngHeader, i)))
+		}(i)
+	}
+	wg.Wait()
+
+	d, err := os.ReadFile(filepath.Join(base, "1", "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := f.meta.Get(filepath.Join("1", "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(d); md.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the checksum of the stored content %q, got %s", d, md.SHA256)
+	}
+}
+
+func TestDeleteFileRejectsInvalidNames(t *testing.T) {
+	f, base := newTestFiles(t, "image/png")
+	r := newRouter(f)
+
+	// a hidden file the storage keeps for itself
+	hidden := filepath.Join(base, "1", ".hidden")
+	if err := os.MkdirAll(filepath.Dir(hidden), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hidden, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{".hidden", `x%5C.hidden`, "a%01.png"} {
+		rw := serve(r, http.MethodDelete, "/images/1/"+fn, nil)
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", fn, rw.Code)
+		}
+	}
+
+	if _, err := os.Stat(hidden); err != nil {
+		t.Errorf("expected the hidden file to be kept, got %v", err)
+	}
+}
+
+// pngImage encodes a w by h PNG
+func pngImage(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newRenditionFiles creates a Files handler resizing images up to 100 pixels
+func newRenditionFiles(t *testing.T) (*Files, string) {
+	t.Helper()
+	f, base := newTestFiles(t, "image/png")
+
+	c, err := images.NewCache(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.WithRenditions(c, 100), base
+}
+
+func TestGetFileRendition(t *testing.T) {
+	f, base := newRenditionFiles(t)
+	r := newRouter(f)
+
+	if rw := serve(r, http.MethodPost, "/images/1/a.png", bytes.NewReader(pngImage(t, 40, 20))); rw.Code >= 300 {
+		t.Fatalf("unable to upload: %d %s", rw.Code, rw.Body)
+	}
+
+	const target = "/images/1/a.png?w=10&h=10&fit=cover&format=jpeg"
+	rw := serve(r, http.MethodGet, target, nil)
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected a jpeg, got %d %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	img, err := jpeg.Decode(rw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Errorf("expected 10x10, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// a second request is served from the cache rather than resized again
+	cached := filepath.Join(base, ".cache", "1", "a.png", "w10-h10-cover-jpeg")
+	if err := os.WriteFile(cached, []byte("cached"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rw := serve(r, http.MethodGet, target, nil); rw.Body.String() != "cached" {
+		t.Errorf("expected the cached rendition, got %d bytes", rw.Body.Len())
+	}
+
+	// overwriting the original purges its renditions
+	if rw := serve(r, http.MethodPost, "/images/1/a.png", bytes.NewReader(pngImage(t, 20, 40))); rw.Code >= 300 {
+		t.Fatalf("unable to overwrite: %d %s", rw.Code, rw.Body)
+	}
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Fatalf("expected the renditions to be purged, got %v", err)
+	}
+	rw = serve(r, http.MethodGet, target, nil)
+	if _, err := jpeg.Decode(rw.Body); rw.Code != http.StatusOK || err != nil {
+		t.Errorf("expected a new rendition, got %d %v", rw.Code, err)
+	}
+
+	// sizes over the maximum dimension are refused
+	if rw := serve(r, http.MethodGet, "/images/1/a.png?w=101", nil); rw.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 over the maximum dimension, got %d", rw.Code)
+	}
+}
+
+// newRouter registers the handlers on the routes main serves them on
+func newRouter(f *Files) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/images/{id:[0-9]+}/{filename}", f.ServeHTTP).Methods(http.MethodPost)
+	r.HandleFunc(`/images/{id:[0-9]+}.{format:zip|tar|tar\.gz}`, f.UploadArchive).Methods(http.MethodPost)
+	r.HandleFunc("/images/{id:[0-9]+}", f.UploadMultipart).Methods(http.MethodPost)
+	r.HandleFunc("/images/{id:[0-9]+}/{filename}", f.GetFile).Methods(http.MethodGet)
+	r.HandleFunc(`/images/{id:[0-9]+}.{format:zip|tar\.gz}`, f.DownloadArchive).Methods(http.MethodGet)
+	r.HandleFunc("/images/{id:[0-9]+}/{filename}/meta", f.GetMetadata).Methods(http.MethodGet)
+	r.HandleFunc("/images/{id:[0-9]+}/{filename}/meta", f.UpdateMetadata).Methods(http.MethodPut)
+	r.HandleFunc("/images/{id:[0-9]+}/{filename}", f.DeleteFile).Methods(http.MethodDelete)
+	r.HandleFunc("/uploads/{id:[0-9]+}/{filename}", f.CreateUpload).Methods(http.MethodPost)
+	r.HandleFunc("/uploads/{upload:[0-9a-f]+}", f.AppendUpload).Methods(http.MethodPatch)
+	r.HandleFunc("/uploads/{upload:[0-9a-f]+}/finalize", f.FinalizeUpload).Methods(http.MethodPost)
+	r.HandleFunc("/trash/{trash:[0-9a-f]+}/restore", f.RestoreTrash).Methods(http.MethodPost)
+	return r
+}
+
+// serve sends a request to h, headers are given as name and value pairs
+func serve(h http.Handler, method, target string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	for i := 0; i < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+	return rw
+}
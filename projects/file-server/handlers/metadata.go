@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	"file-server/files"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// metadataRequest is the body accepted by UpdateMetadata, only the client
+// editable fields, everything else is recorded by the server at upload
+type metadataRequest struct {
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// GetMetadata returns the metadata recorded for a file
+func (f *Files) GetMetadata(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fp := filepath.Join(vars["id"], vars["filename"])
+
+	f.logger(r.Context()).Info("Handle GET metadata", "path", fp)
+
+	if !f.exists(r.Context(), fp) {
+		http.NotFound(rw, r)
+		return
+	}
+
+	md, err := f.meta.Get(fp)
+	if xerrors.Is(err, files.ErrMetadataNotFound) {
+		// stored before metadata was recorded
+		md, err = &files.Metadata{}, nil
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to read metadata", "path", fp, "error", err)
+		http.Error(rw, "Unable to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(md)
+}
+
+// UpdateMetadata replaces the description and labels of a file
+func (f *Files) UpdateMetadata(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fp := filepath.Join(vars["id"], vars["filename"])
+
+	f.logger(r.Context()).Info("Handle PUT metadata", "path", fp)
+
+	if !f.exists(r.Context(), fp) {
+		http.NotFound(rw, r)
+		return
+	}
+
+	req := &metadataRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 64*1024)).Decode(req)
+	if err != nil {
+		http.Error(rw, "Unable to unmarshal json", http.StatusBadRequest)
+		return
+	}
+
+	md, err := f.meta.Update(fp, func(md *files.Metadata) error {
+		md.Description = req.Description
+		md.Labels = req.Labels
+		return md.Validate()
+	})
+	if xerrors.Is(err, files.ErrInvalidMetadata) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to update metadata", "path", fp, "error", err)
+		http.Error(rw, "Unable to update metadata", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(md)
+}
+
+// exists reports whether the storage has a file at the path, a file which
+// can't be checked is treated as missing
+func (f *Files) exists(ctx context.Context, fp string) bool {
+	ok, err := f.storage(ctx).Exists(fp)
+	if err != nil {
+		f.logger(ctx).Error("Unable to check file exists", "path", fp, "error", err)
+		return false
+	}
+	return ok
+}
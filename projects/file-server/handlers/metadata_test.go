@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"file-server/files"
+)
+
+func TestMetadataHandlers(t *testing.T) {
+	f, _ := newTestFiles(t)
+	r := newRouter(f)
+
+	rw := serve(r, http.MethodPost, "/images/1/a.png", bytes.NewReader(pngHeader))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unable to upload: %d %s", rw.Code, rw.Body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"get", http.MethodGet, "/images/1/a.png/meta", "", http.StatusOK},
+		{"get missing file", http.MethodGet, "/images/1/b.png/meta", "", http.StatusNotFound},
+		{"update", http.MethodPut, "/images/1/a.png/meta", `{"description":"front","labels":{"color":"red"}}`, http.StatusOK},
+		{"update missing file", http.MethodPut, "/images/1/b.png/meta", `{"description":"front"}`, http.StatusNotFound},
+		{"invalid json", http.MethodPut, "/images/1/a.png/meta", `{"description":`, http.StatusBadRequest},
+		{"invalid label", http.MethodPut, "/images/1/a.png/meta", `{"labels":{"":"red"}}`, http.StatusBadRequest},
+		{"long description", http.MethodPut, "/images/1/a.png/meta", `{"description":"` + strings.Repeat("d", 4097) + `"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rw := serve(r, tt.method, tt.path, strings.NewReader(tt.body))
+		if rw.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, rw.Code, rw.Body)
+		}
+	}
+
+	// the failed updates left the valid one in place
+	rw = serve(r, http.MethodGet, "/images/1/a.png/meta", nil)
+	md := &files.Metadata{}
+	json.NewDecoder(rw.Body).Decode(md)
+	if md.Description != "front" || md.Labels["color"] != "red" || md.ContentType != "image/png" {
+		t.Errorf("unexpected metadata %+v", md)
+	}
+}
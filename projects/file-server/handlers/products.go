@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"file-server/products"
+
+	"github.com/gorilla/mux"
+)
+
+// WithProducts makes uploads check the {id} is an existing product using c
+func (f *Files) WithProducts(c products.Client) *Files {
+	f.products = c
+	return f
+}
+
+// MiddlewareProductExists refuses requests for an {id} which is not a product in
+// product-api. Requests without an {id} and handlers without a products client
+// are passed straight through
+func (f *Files) MiddlewareProductExists(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, ok := mux.Vars(r)["id"]
+		if f.products == nil || !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		exists, err := f.products.Exists(r.Context(), id)
+		if err != nil {
+			// without product-api we can't tell, refuse rather than store orphans
+			f.logger(r.Context()).Error("Unable to check product", "id", id, "error", err)
+			http.Error(rw, "Unable to check product", http.StatusServiceUnavailable)
+			return
+		}
+		if !exists {
+			http.Error(rw, "Product "+id+" not found", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-server/products"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+)
+
+// fakeProducts is a products.Client backed by a set of ids
+type fakeProducts struct {
+	ids map[string]bool
+	err error
+}
+
+func (fp *fakeProducts) Exists(ctx context.Context, id string) (bool, error) {
+	return fp.ids[id], fp.err
+}
+
+func TestMiddlewareProductExists(t *testing.T) {
+	tests := []struct {
+		name   string
+		client products.Client
+		path   string
+		want   int
+	}{
+		{"existing product", &fakeProducts{ids: map[string]bool{"1": true}}, "/images/1/a.png", http.StatusOK},
+		{"missing product", &fakeProducts{ids: map[string]bool{"1": true}}, "/images/2/a.png", http.StatusNotFound},
+		{"product-api down", &fakeProducts{err: products.ErrUnavailable}, "/images/1/a.png", http.StatusServiceUnavailable},
+		{"no id in route", &fakeProducts{}, "/uploads/abc/finalize", http.StatusOK},
+		{"check disabled", nil, "/images/2/a.png", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		f := NewFiles(nil, nil, nil, hclog.NewNullLogger())
+		if tt.client != nil {
+			f.WithProducts(tt.client)
+		}
+
+		r := mux.NewRouter()
+		r.Use(f.MiddlewareProductExists)
+		ok := func(rw http.ResponseWriter, r *http.Request) {}
+		r.HandleFunc("/images/{id:[0-9]+}/{filename}", ok)
+		r.HandleFunc("/uploads/{upload}/finalize", ok)
+
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if rw.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-server/files"
+
+	"github.com/gorilla/mux"
+)
+
+// usageResponse is returned by Usage, limits of zero are unlimited
+type usageResponse struct {
+	ID         string `json:"id"`
+	Bytes      int64  `json:"bytes"`
+	Files      int64  `json:"files"`
+	LimitBytes int64  `json:"limit_bytes"`
+	LimitFiles int64  `json:"limit_files"`
+}
+
+// WithQuotas enables the usage endpoint, q must be the storage the handler saves to
+func (f *Files) WithQuotas(q *files.QuotaStorage) *Files {
+	f.quotas = q
+	return f
+}
+
+// Usage returns the space used by a product id and its quota
+func (f *Files) Usage(rw http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	f.logger(r.Context()).Info("Handle GET usage", "id", id)
+
+	u := f.quotas.Usage(id)
+	limits := f.quotas.Limits()
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(usageResponse{
+		ID:         id,
+		Bytes:      u.Bytes,
+		Files:      u.Files,
+		LimitBytes: limits.IDBytes,
+		LimitFiles: limits.IDFiles,
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"shared/signing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/xerrors"
+)
+
+// maxSignedURLLifetime bounds how long a minted URL stays valid
+const maxSignedURLLifetime = 7 * 24 * time.Hour
+
+// SignedURLs mints signed URLs for the /images routes and verifies them
+type SignedURLs struct {
+	log    hclog.Logger
+	keys   atomic.Pointer[signingKeys]
+	target SignedTarget
+}
+
+// SignedTarget returns the method and /images path whose signature authorises a
+// request, ok is false for requests which don't need a signature
+type SignedTarget func(r *http.Request) (method, path string, ok bool)
+
+// signingKeys are swapped together so a request never sees half of a change
+type signingKeys struct {
+	signer   *signing.Signer
+	adminKey string
+	required bool
+}
+
+// NewSignedURLs creates the signed URL handler and middleware
+// adminKey guards the signing endpoint, when required is true requests
+// to /images without a valid signature are refused. A nil signer disables
+// signed URLs until SetKeys is given one
+func NewSignedURLs(s *signing.Signer, adminKey string, required bool, l hclog.Logger) *SignedURLs {
+	su := &SignedURLs{log: l, target: imagesTarget}
+	su.SetKeys(s, adminKey, required)
+	return su
+}
+
+// WithTarget sets how requests are matched to the /images URL they are signed for,
+// by default only requests to /images are verified
+func (s *SignedURLs) WithTarget(t SignedTarget) *SignedURLs {
+	s.target = t
+	return s
+}
+
+// imagesTarget verifies requests to /images against their own method and path
+func imagesTarget(r *http.Request) (string, string, bool) {
+	return r.Method, r.URL.Path, strings.HasPrefix(r.URL.Path, "/images/")
+}
+
+// SetKeys replaces the signer and admin key, URLs signed with the previous
+// key stop being accepted
+func (s *SignedURLs) SetKeys(signer *signing.Signer, adminKey string, required bool) {
+	s.keys.Store(&signingKeys{signer: signer, adminKey: adminKey, required: required})
+}
+
+// signRequest is the body accepted by Sign
+type signRequest struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	ExpiresIn string `json:"expires_in"` // duration, e.g. 15m
+	MaxSize   int64  `json:"max_size"`   // bytes, 0 for no limit
+}
+
+// signResponse is returned by Sign
+type signResponse struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sign mints a signed URL, the request must carry the admin key as a bearer token
+func (s *SignedURLs) Sign(rw http.ResponseWriter, r *http.Request) {
+	k := s.keys.Load()
+	if k.signer == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if !k.isAdmin(r) {
+		s.logger(r.Context()).Warn("Rejected sign request without admin key", "remote", r.RemoteAddr)
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req := &signRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096)).Decode(req)
+	if err != nil {
+		http.Error(rw, "Unable to unmarshal json", http.StatusBadRequest)
+		return
+	}
+
+	req.Method = strings.ToUpper(req.Method)
+	switch req.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(rw, "method must be GET, POST, PUT or DELETE", http.StatusBadRequest)
+		return
+	}
+
+	if !strings.HasPrefix(req.Path, "/images/") || strings.Contains(req.Path, "..") {
+		http.Error(rw, "path must be under /images/", http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil || ttl <= 0 || ttl > maxSignedURLLifetime {
+		http.Error(rw, "expires_in must be a duration up to 168h", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxSize < 0 {
+		http.Error(rw, "max_size can not be negative", http.StatusBadRequest)
+		return
+	}
+
+	expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	q := k.signer.Sign(req.Method, req.Path, expires, req.MaxSize)
+
+	s.logger(r.Context()).Info("Signed URL", "method", req.Method, "path", req.Path, "expires", expires, "max_size", req.MaxSize)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(signResponse{
+		URL:       baseURL(r) + req.Path + "?" + q.Encode(),
+		Method:    req.Method,
+		ExpiresAt: expires,
+	})
+}
+
+// logger returns the logger for the request in ctx, tagged with its request id
+func (s *SignedURLs) logger(ctx context.Context) hclog.Logger {
+	return requestLogger(s.log, ctx)
+}
+
+// Middleware verifies signed URLs for the requests matched by the target, see WithTarget
+// a valid signature may limit the size of the request body, requests without
+// a signature are passed through unless signatures are required
+func (s *SignedURLs) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		k := s.keys.Load()
+		if k.signer == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		method, path, ok := s.target(r)
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		maxSize, err := k.signer.Verify(method, path, r.URL.Query(), time.Now())
+		if xerrors.Is(err, signing.ErrMissingSignature) && !k.required {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if err != nil {
+			s.logger(r.Context()).Warn("Rejected signed URL", "method", r.Method, "path", r.URL.Path, "signed_path", path, "error", err)
+			if xerrors.Is(err, signing.ErrMissingSignature) {
+				http.Error(rw, "Signed URL required", http.StatusForbidden)
+				return
+			}
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if maxSize > 0 {
+			// a resumable upload declares the size of the whole file up front
+			if l, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64); err == nil && l > maxSize {
+				http.Error(rw, "Upload-Length exceeds the signed size limit", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.ContentLength > maxSize {
+				http.Error(rw, "Request body exceeds the signed size limit", http.StatusRequestEntityTooLarge)
+				return
+			}
+			// the body may not declare its length, so cap what we will read
+			r.Body = http.MaxBytesReader(rw, r.Body, maxSize)
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// SignedTarget matches requests to the /images URL which authorises them.
+// Every step of a resumable upload, and restoring a trashed file, is covered by
+// the URL signed for POST to the file it creates, purging a trashed file by the
+// URL signed for DELETE. Uploads and trash items which can't be found are checked
+// against their own path, which no signature covers
+func (f *Files) SignedTarget(r *http.Request) (string, string, bool) {
+	vars := mux.Vars(r)
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/images/"):
+		return r.Method, r.URL.Path, true
+
+	case vars["upload"] != "":
+		if f.uploads == nil {
+			return r.Method, r.URL.Path, true
+		}
+		up, err := f.uploads.Get(vars["upload"])
+		if err != nil {
+			return r.Method, r.URL.Path, true
+		}
+		return http.MethodPost, imagePath(up.ID, up.Filename), true
+
+	case strings.HasPrefix(r.URL.Path, "/uploads/"):
+		return http.MethodPost, imagePath(vars["id"], vars["filename"]), true
+
+	case vars["trash"] != "":
+		if f.trash == nil {
+			return r.Method, r.URL.Path, true
+		}
+		item, err := f.trash.Get(vars["trash"])
+		if err != nil {
+			return r.Method, r.URL.Path, true
+		}
+		if r.Method == http.MethodDelete {
+			return http.MethodDelete, imagePath(item.ID, item.Filename), true
+		}
+		return http.MethodPost, imagePath(item.ID, item.Filename), true
+	}
+
+	return "", "", false
+}
+
+// imagePath returns the /images URL path of a file
+func imagePath(id, filename string) string {
+	return "/images/" + id + "/" + filename
+}
+
+// isAdmin checks the bearer token against the admin key in constant time
+func (k *signingKeys) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || k.adminKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(k.adminKey)) == 1
+}
+
+// baseURL returns the scheme and host the client used to reach us
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"file-server/files"
+	"shared/signing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+)
+
+// newSignedRouter serves f with signatures required, uploads and the trash enabled
+func newSignedRouter(t *testing.T) (*mux.Router, *Files, *signing.Signer) {
+	t.Helper()
+	f, base := newTestFiles(t, "image/png")
+
+	up, err := files.NewUploads(base, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := files.NewTrash(base, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WithUploads(up).WithTrash(tr)
+
+	s := signing.NewSigner([]byte("secret"))
+	su := NewSignedURLs(s, "admin", true, hclog.NewNullLogger()).WithTarget(f.SignedTarget)
+
+	r := newRouter(f)
+	r.Use(su.Middleware)
+	return r, f, s
+}
+
+// signed returns the query authorising method on path for an hour
+func signed(s *signing.Signer, method, path string, maxSize int64) string {
+	return "?" + s.Sign(method, path, time.Now().Add(time.Hour), maxSize).Encode()
+}
+
+func TestSignedURLsRequiredForUploadsAndTrash(t *testing.T) {
+	r, f, _ := newSignedRouter(t)
+
+	up, err := f.uploads.Create("1", "a.png", int64(len(pngHeader)), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.uploads.Append(up.UploadID, 0, bytes.NewReader(pngHeader))
+
+	item, err := f.trash.Put("1/b.png", bytes.NewReader(pngHeader), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, target string
+		headers              []string
+	}{
+		{"upload", http.MethodPost, "/uploads/1/a.png", []string{"Upload-Length", "10"}},
+		{"chunk", http.MethodPatch, "/uploads/" + up.UploadID, []string{"Content-Type", "application/offset+octet-stream", "Upload-Offset", "0"}},
+		{"finalize", http.MethodPost, "/uploads/" + up.UploadID + "/finalize", nil},
+		{"restore", http.MethodPost, "/trash/" + item.TrashID + "/restore", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := serve(r, tc.method, tc.target, nil, tc.headers...)
+			if rw.Code != http.StatusForbidden {
+				t.Errorf("expected 403, got %d: %s", rw.Code, rw.Body)
+			}
+		})
+	}
+}
+
+func TestSignedResumableUpload(t *testing.T) {
+	r, _, s := newSignedRouter(t)
+	q := signed(s, http.MethodPost, "/images/1/a.png", 1024)
+
+	// a URL signed for another file does not cover this one
+	rw := serve(r, http.MethodPost, "/uploads/1/a.png"+signed(s, http.MethodPost, "/images/1/b.png", 0), nil, "Upload-Length", "10")
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rw.Code)
+	}
+
+	rw = serve(r, http.MethodPost, "/uploads/1/a.png"+q, nil, "Upload-Length", "2048")
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a length over the signed limit, got %d", rw.Code)
+	}
+
+	rw = serve(r, http.MethodPost, "/uploads/1/a.png"+q, nil, "Upload-Length", "16")
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+
+	// the location keeps the signature for the rest of the upload
+	loc := rw.Header().Get("Location")
+	if !strings.Contains(loc, signing.ParamSignature+"=") {
+		t.Fatalf("expected the location to be signed, got %s", loc)
+	}
+	path, query, _ := strings.Cut(loc, "?")
+
+	rw = serve(r, http.MethodPatch, loc, bytes.NewReader(pngHeader), "Content-Type", "application/offset+octet-stream", "Upload-Offset", "0")
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rw.Code, rw.Body)
+	}
+
+	rw = serve(r, http.MethodPost, path+"/finalize?"+query, nil)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+}
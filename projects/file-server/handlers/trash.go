@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"file-server/files"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// WithTrash makes DeleteFile move files to t so they can be restored
+func (f *Files) WithTrash(t *files.Trash) *Files {
+	f.trash = t
+	return f
+}
+
+// moveToTrash copies the file at fp and its metadata into the trash
+func (f *Files) moveToTrash(ctx context.Context, fp string) (*files.TrashItem, error) {
+	src, err := f.storage(ctx).Get(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	md, err := f.meta.Get(fp)
+	if err != nil && !xerrors.Is(err, files.ErrMetadataNotFound) {
+		return nil, err
+	}
+
+	return f.trash.Put(fp, src, md)
+}
+
+// ListTrash returns the files in the trash, optionally only those for ?id=
+func (f *Files) ListTrash(rw http.ResponseWriter, r *http.Request) {
+	f.logger(r.Context()).Info("Handle GET trash")
+
+	items, err := f.trash.List()
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to list trash", "error", err)
+		http.Error(rw, "Unable to list trash", http.StatusInternalServerError)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		filtered := []files.TrashItem{}
+		for _, it := range items {
+			if it.ID == id {
+				filtered = append(filtered, it)
+			}
+		}
+		items = filtered
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(items)
+}
+
+// RestoreTrash puts a trashed file back where it was deleted from, an existing
+// file at that path is only replaced when ?overwrite=true
+func (f *Files) RestoreTrash(rw http.ResponseWriter, r *http.Request) {
+	tid := mux.Vars(r)["trash"]
+
+	f.logger(r.Context()).Info("Handle restore", "trash", tid)
+
+	item, data, err := f.trash.Open(tid)
+	if xerrors.Is(err, files.ErrTrashItemNotFound) {
+		http.Error(rw, "Trash item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to open trash item", "trash", tid, "error", err)
+		http.Error(rw, "Unable to restore file", http.StatusInternalServerError)
+		return
+	}
+	defer data.Close()
+
+	unlock := f.lockPath(item.Path)
+	defer unlock()
+
+	if r.URL.Query().Get("overwrite") != "true" && f.exists(r.Context(), item.Path) {
+		http.Error(rw, "A file already exists at "+item.Path, http.StatusConflict)
+		return
+	}
+
+	// the contents were checked when first uploaded so go straight to storage,
+	// quotas still apply
+	replaced, err := f.overwrite(r.Context(), item.Path, data)
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to restore file", "trash", tid, "error", err)
+		f.saveError(rw, err)
+		return
+	}
+
+	if item.Metadata != nil {
+		err = f.meta.Put(item.Path, item.Metadata)
+	} else {
+		err = f.meta.Delete(item.Path)
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to restore metadata", "path", item.Path, "error", err)
+	}
+	f.purgeRenditions(item.Path)
+
+	err = f.trash.Remove(tid)
+	if err != nil {
+		// the file is back, the stale item is collected when it expires
+		f.logger(r.Context()).Error("Unable to remove restored trash item", "trash", tid, "error", err)
+	}
+
+	fi := &files.FileInfo{
+		ID:       item.ID,
+		Filename: item.Filename,
+		Size:     item.Size,
+		Path:     item.Path,
+		ModTime:  time.Now().UTC(),
+	}
+	if item.Metadata != nil {
+		fi.ApplyMetadata(item.Metadata)
+	}
+	f.publishSave(replaced, fi)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(fi)
+}
+
+// PurgeTrash permanently deletes a trashed file before its retention ends
+func (f *Files) PurgeTrash(rw http.ResponseWriter, r *http.Request) {
+	tid := mux.Vars(r)["trash"]
+
+	f.logger(r.Context()).Info("Handle DELETE trash", "trash", tid)
+
+	_, err := f.trash.Get(tid)
+	if err == nil {
+		err = f.trash.Remove(tid)
+	}
+	if xerrors.Is(err, files.ErrTrashItemNotFound) {
+		http.Error(rw, "Trash item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to purge trash item", "trash", tid, "error", err)
+		http.Error(rw, "Unable to purge trash item", http.StatusInternalServerError)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
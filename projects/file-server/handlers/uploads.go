@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"file-server/files"
+	"shared/signing"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// Resumable uploads follow the shape of the tus protocol:
+//
+//	POST   /uploads/{id}/{filename}  with Upload-Length creates an upload, Location has its url
+//	PATCH  /uploads/{upload}         with Upload-Offset appends a chunk
+//	HEAD   /uploads/{upload}         returns the Upload-Offset to resume from
+//	POST   /uploads/{upload}/finalize validates the file and moves it into storage
+//	DELETE /uploads/{upload}         abandons the upload
+
+// WithUploads enables resumable uploads staged in u
+func (f *Files) WithUploads(u *files.Uploads) *Files {
+	f.uploads = u
+	return f
+}
+
+// CreateUpload starts a resumable upload for {id}/{filename}
+func (f *Files) CreateUpload(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	fn, err := files.CleanFilename(vars["filename"])
+	if err != nil || fn != vars["filename"] {
+		http.Error(rw, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(rw, "Upload-Length header is required", http.StatusBadRequest)
+		return
+	}
+
+	f.logger(r.Context()).Info("Handle POST upload", "id", id, "filename", fn, "length", length)
+
+	up, err := f.uploads.Create(id, fn, length, uploader(r))
+	if xerrors.Is(err, files.ErrFileTooLarge) {
+		http.Error(rw, "File exceeds the maximum allowed size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to create upload", "error", err)
+		http.Error(rw, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	// the signature which authorised the upload also covers its chunks and finalize
+	loc := "/uploads/" + up.UploadID
+	if r.URL.Query().Get(signing.ParamSignature) != "" {
+		loc += "?" + r.URL.RawQuery
+	}
+
+	rw.Header().Set("Location", loc)
+	rw.Header().Set("Upload-Offset", "0")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(up)
+}
+
+// UploadStatus reports how much of an upload has been received
+func (f *Files) UploadStatus(rw http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["upload"]
+
+	up, err := f.uploads.Get(uid)
+	if err != nil {
+		f.uploadError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	rw.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	// the offset changes with every chunk, it must never be cached
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+}
+
+// AppendUpload writes a chunk to an upload, the chunk must start at the Upload-Offset
+// returned by the last chunk or by UploadStatus
+func (f *Files) AppendUpload(rw http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["upload"]
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(rw, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(rw, "Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	f.logger(r.Context()).Debug("Handle PATCH upload", "upload", uid, "offset", offset)
+
+	n, err := f.uploads.Append(uid, offset, r.Body)
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to append chunk", "upload", uid, "offset", offset, "error", err)
+		rw.Header().Set("Upload-Offset", strconv.FormatInt(n, 10))
+		f.uploadError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Upload-Offset", strconv.FormatInt(n, 10))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// FinalizeUpload moves a complete upload into storage, it goes through the same
+// content type and checksum validation as a direct upload
+func (f *Files) FinalizeUpload(rw http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["upload"]
+
+	f.logger(r.Context()).Info("Handle finalize upload", "upload", uid)
+
+	// Digest or Content-MD5 on the finalize request verifies the whole file
+	var fi *files.FileInfo
+	var saveErr error
+	_, err := f.uploads.Finalize(uid, func(up *files.Upload, data io.Reader) error {
+		fi, saveErr = f.save(r.Context(), up.ID, up.Filename, data, r.Header, up.Uploader)
+		return saveErr
+	})
+	if saveErr != nil {
+		f.logger(r.Context()).Error("Unable to save upload", "upload", uid, "error", saveErr)
+		f.saveError(rw, saveErr)
+		return
+	}
+	if err != nil {
+		f.uploadError(rw, err)
+		return
+	}
+
+	err = f.uploads.Remove(uid)
+	if err != nil {
+		// the file is saved, the staged copy will be collected once it expires
+		f.logger(r.Context()).Error("Unable to remove finished upload", "upload", uid, "error", err)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(fi)
+}
+
+// AbortUpload deletes an upload and everything staged for it
+func (f *Files) AbortUpload(rw http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["upload"]
+
+	f.logger(r.Context()).Info("Handle DELETE upload", "upload", uid)
+
+	_, err := f.uploads.Get(uid)
+	if err == nil {
+		err = f.uploads.Remove(uid)
+	}
+	if err != nil {
+		f.uploadError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// uploadError writes the http error matching a failed upload operation
+func (f *Files) uploadError(rw http.ResponseWriter, err error) {
+	switch {
+	case xerrors.Is(err, files.ErrUploadNotFound):
+		http.Error(rw, "Upload not found", http.StatusNotFound)
+	case xerrors.Is(err, files.ErrOffsetMismatch):
+		http.Error(rw, "Upload-Offset does not match the received data", http.StatusConflict)
+	case xerrors.Is(err, files.ErrUploadIncomplete):
+		http.Error(rw, "Upload is incomplete", http.StatusConflict)
+	case xerrors.Is(err, files.ErrUploadFinalized):
+		http.Error(rw, "Upload is already finalized", http.StatusConflict)
+	case xerrors.Is(err, files.ErrFileTooLarge):
+		http.Error(rw, "Chunk exceeds the upload length", http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(rw, "Unable to process upload", http.StatusInternalServerError)
+	}
+}
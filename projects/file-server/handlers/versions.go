@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"file-server/events"
+	"file-server/files"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// versionsResponse is returned by ListVersions
+type versionsResponse struct {
+	ID       string          `json:"id"`
+	Filename string          `json:"filename"`
+	Current  int             `json:"current"`
+	Versions []files.Version `json:"versions"`
+}
+
+// WithVersions makes overwrites keep the prior content in v
+func (f *Files) WithVersions(v *files.Versions) *Files {
+	f.versions = v
+	return f
+}
+
+// overwrite saves contents to fp, keeping the content it replaces as a version
+// when versioning is enabled. It reports whether there was a file at fp to replace.
+// The caller must hold lockPath(fp) until it has also written the file's metadata
+func (f *Files) overwrite(ctx context.Context, fp string, contents io.Reader) (bool, error) {
+	if f.versions == nil {
+		replaced := f.exists(ctx, fp)
+		return replaced, f.storage(ctx).Save(fp, contents)
+	}
+
+	ver, err := f.keepVersion(ctx, fp)
+	if err != nil {
+		return false, err
+	}
+
+	err = f.storage(ctx).Save(fp, contents)
+	if err != nil {
+		// nothing was replaced so the version isn't needed
+		if ver != nil {
+			if derr := f.versions.Discard(fp, ver); derr != nil {
+				f.logger(ctx).Error("Unable to discard version", "path", fp, "error", derr)
+			}
+		}
+		return false, err
+	}
+
+	_, err = f.versions.Prune(fp)
+	if err != nil {
+		f.logger(ctx).Error("Unable to prune versions", "path", fp, "error", err)
+	}
+
+	return ver != nil, nil
+}
+
+// keepVersion copies the current content of fp into the version store, returning
+// nil when there is no file at fp yet
+func (f *Files) keepVersion(ctx context.Context, fp string) (*files.Version, error) {
+	src, err := f.storage(ctx).Get(fp)
+	if err != nil {
+		return nil, nil
+	}
+	defer src.Close()
+
+	md, err := f.meta.Get(fp)
+	if err != nil && !xerrors.Is(err, files.ErrMetadataNotFound) {
+		return nil, err
+	}
+
+	return f.versions.Keep(fp, src, md)
+}
+
+// ListVersions returns the kept versions of a file and the number of the current one
+func (f *Files) ListVersions(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+	fp := filepath.Join(id, fn)
+
+	f.logger(r.Context()).Info("Handle GET versions", "id", id, "filename", fn)
+
+	vers, err := f.versions.List(fp)
+	if err != nil {
+		f.versionError(rw, err)
+		return
+	}
+
+	resp := versionsResponse{ID: id, Filename: fn, Versions: vers}
+	if f.exists(r.Context(), fp) {
+		resp.Current, err = f.versions.Current(fp)
+		if err != nil {
+			f.versionError(rw, err)
+			return
+		}
+	} else if len(vers) == 0 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(resp)
+}
+
+// getVersion serves a kept version of a file for GET ?version=N, the current
+// version number serves the file itself. Versions are only served while the
+// file exists, those of a deleted file wait in the store for it to be restored
+func (f *Files) getVersion(rw http.ResponseWriter, r *http.Request, fp string) bool {
+	n, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || n < 1 {
+		http.Error(rw, "version must be a positive number", http.StatusBadRequest)
+		return true
+	}
+
+	if !f.exists(r.Context(), fp) {
+		http.NotFound(rw, r)
+		return true
+	}
+
+	if cur, err := f.versions.Current(fp); err == nil && cur == n {
+		return false
+	}
+
+	ver, data, err := f.versions.Open(fp, n)
+	if err != nil {
+		f.versionError(rw, err)
+		return true
+	}
+	defer data.Close()
+
+	if ver.SHA256 != "" {
+		rw.Header().Set("Digest", files.DigestHeader(ver.SHA256))
+	}
+	http.ServeContent(rw, r, filepath.Base(fp), ver.ReplacedAt, data)
+	return true
+}
+
+// RollbackVersion makes a kept version the current content of the file again,
+// the content it replaces is kept as a new version so a rollback can be undone
+func (f *Files) RollbackVersion(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+	fp := filepath.Join(id, fn)
+
+	n, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	f.logger(r.Context()).Info("Handle rollback", "id", id, "filename", fn, "version", n)
+
+	ver, data, err := f.versions.Open(fp, n)
+	if err != nil {
+		f.versionError(rw, err)
+		return
+	}
+	defer data.Close()
+
+	unlock := f.lockPath(fp)
+	defer unlock()
+
+	_, err = f.overwrite(r.Context(), fp, data)
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to roll back file", "path", fp, "version", n, "error", err)
+		f.saveError(rw, err)
+		return
+	}
+
+	if ver.Metadata != nil {
+		err = f.meta.Put(fp, ver.Metadata)
+	} else {
+		err = f.meta.Delete(fp)
+	}
+	if err != nil {
+		f.logger(r.Context()).Error("Unable to restore metadata", "path", fp, "error", err)
+	}
+	f.purgeRenditions(fp)
+
+	fi := &files.FileInfo{
+		ID:       id,
+		Filename: fn,
+		Size:     ver.Size,
+		Path:     fp,
+		ModTime:  time.Now().UTC(),
+	}
+	if ver.Metadata != nil {
+		fi.ApplyMetadata(ver.Metadata)
+	}
+	f.publish(events.FileOverwritten, fi)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(fi)
+}
+
+// DeleteVersion permanently removes a kept version
+func (f *Files) DeleteVersion(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+
+	n, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	f.logger(r.Context()).Info("Handle DELETE version", "id", id, "filename", fn, "version", n)
+
+	err = f.versions.Remove(filepath.Join(id, fn), n)
+	if err != nil {
+		f.versionError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// versionError writes the http error matching a failed version lookup
+func (f *Files) versionError(rw http.ResponseWriter, err error) {
+	if xerrors.Is(err, files.ErrVersionNotFound) {
+		http.Error(rw, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	f.log.Error("Unable to read versions", "error", err)
+	http.Error(rw, "Unable to read versions", http.StatusInternalServerError)
+}
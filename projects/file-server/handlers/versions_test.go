@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"file-server/files"
+)
+
+// newVersionedFiles creates a Files handler keeping up to 100 versions of each file
+func newVersionedFiles(t *testing.T) *Files {
+	t.Helper()
+	f, base := newTestFiles(t, "image/png")
+
+	v, err := files.NewVersions(base, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.WithVersions(v)
+}
+
+func TestGetVersionOfDeletedFile(t *testing.T) {
+	f := newVersionedFiles(t)
+	r := newRouter(f)
+
+	for _, c := range []string{"one", "two"} {
+		rw := serve(r, http.MethodPost, "/images/1/a.png", bytes.NewBufferString(string(pngHeader)+c))
+		if rw.Code >= 300 {
+			t.Fatalf("unable to upload: %d %s", rw.Code, rw.Body)
+		}
+	}
+
+	rw := serve(r, http.MethodGet, "/images/1/a.png?version=1", nil)
+	if rw.Code != http.StatusOK || rw.Body.String() != string(pngHeader)+"one" {
+		t.Fatalf("expected version 1, got %d %q", rw.Code, rw.Body)
+	}
+
+	if rw := serve(r, http.MethodDelete, "/images/1/a.png", nil); rw.Code >= 300 {
+		t.Fatalf("unable to delete: %d %s", rw.Code, rw.Body)
+	}
+
+	// the versions are kept but not served while the file is deleted
+	rw = serve(r, http.MethodGet, "/images/1/a.png?version=1", nil)
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a version of a deleted file, got %d", rw.Code)
+	}
+	if vers, _ := f.versions.List("1/a.png"); len(vers) != 1 {
+		t.Errorf("expected the version to be kept, got %+v", vers)
+	}
+}
+
+func TestConcurrentOverwritesKeepEveryVersion(t *testing.T) {
+	f := newVersionedFiles(t)
+	r := newRouter(f)
+
+	serve(r, http.MethodPost, "/images/1/a.png", bytes.NewBufferString(string(pngHeader)+"0"))
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			serve(r, http.MethodPost, "/images/1/a.png", bytes.NewBufferString(fmt.Sprintf("%s%d", pngHeader, i)))
+		}(i)
+	}
+	wg.Wait()
+
+	vers, err := f.versions.List("1/a.png")
+	if err != nil || len(vers) != n {
+		t.Fatalf("expected %d versions, got %d %v", n, len(vers), err)
+	}
+
+	// every content, the current one included, is there exactly once
+	seen := map[string]bool{}
+	for _, v := range vers {
+		_, data, err := f.versions.Open("1/a.png", v.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _ := io.ReadAll(data)
+		data.Close()
+		seen[string(d)] = true
+	}
+	rw := serve(r, http.MethodGet, "/images/1/a.png", nil)
+	seen[rw.Body.String()] = true
+
+	if len(seen) != n+1 {
+		t.Errorf("expected %d distinct contents, got %d", n+1, len(seen))
+	}
+}
@@ -0,0 +1,90 @@
+package images
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// cacheDir is the hidden directory under the base path holding derived renditions
+const cacheDir = ".cache"
+
+// Cache stores renditions on disk so each size is only generated once,
+// renditions of {id}/{filename} live in {basePath}/.cache/{id}/{filename}/
+type Cache struct {
+	basePath string
+}
+
+// NewCache creates a rendition Cache under basePath
+func NewCache(basePath string) (*Cache, error) {
+	p, err := filepath.Abs(filepath.Join(basePath, cacheDir))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{basePath: p}, nil
+}
+
+// Get returns the cached rendition named key for the file at path, a rendition
+// older than the source (modified at srcMod) is stale and treated as missing.
+// The calling function is responsible for closing the file
+func (c *Cache) Get(path, key string, srcMod time.Time) (*os.File, error) {
+	f, err := os.Open(filepath.Join(c.basePath, path, key))
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil || fi.ModTime().Before(srcMod) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+
+	return f, nil
+}
+
+// Put generates a rendition by calling write and stores it as key for the file at path,
+// the stored file is returned open
+func (c *Cache) Put(path, key string, write func(w io.Writer) error) (*os.File, error) {
+	d := filepath.Join(c.basePath, path)
+	err := os.MkdirAll(d, os.ModePerm)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create cache directory: %w", err)
+	}
+
+	// generate into a temporary file, concurrent requests for the same rendition
+	// each write their own and the last rename wins
+	tmp, err := os.CreateTemp(d, ".rendition-*")
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create cache file: %w", err)
+	}
+
+	err = write(tmp)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	fp := filepath.Join(d, key)
+	err = os.Rename(tmp.Name(), fp)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, xerrors.Errorf("Unable to store rendition: %w", err)
+	}
+
+	return os.Open(fp)
+}
+
+// Purge removes every rendition of the file at path
+func (c *Cache) Purge(path string) error {
+	err := os.RemoveAll(filepath.Join(c.basePath, path))
+	if err != nil {
+		return xerrors.Errorf("Unable to purge renditions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package images
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidOptions is returned when the resize query parameters are not usable
+var ErrInvalidOptions = xerrors.New("Invalid image options")
+
+// Fit modes control how an image is placed into the requested width and height
+const (
+	// FitContain scales the image to fit inside the box keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to fill the box keeping its aspect ratio, cropping the overflow
+	FitCover = "cover"
+	// FitFill stretches the image to exactly the box
+	FitFill = "fill"
+)
+
+// Options describes the rendition requested with ?w=&h=&fit=&format=
+type Options struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string // png, jpeg or gif, empty keeps the source format
+}
+
+// HasOptions reports whether the query asks for a rendition rather than the original
+func HasOptions(q url.Values) bool {
+	return q.Get("w") != "" || q.Get("h") != "" || q.Get("fit") != "" || q.Get("format") != ""
+}
+
+// ParseOptions reads the rendition options from the query, width and height
+// must be between 1 and maxDimension so clients can't ask for huge images
+func ParseOptions(q url.Values, maxDimension int) (*Options, error) {
+	o := &Options{Fit: FitContain}
+
+	var err error
+	o.Width, err = parseDimension(q.Get("w"), maxDimension)
+	if err != nil {
+		return nil, xerrors.Errorf("w %s: %w", err, ErrInvalidOptions)
+	}
+	o.Height, err = parseDimension(q.Get("h"), maxDimension)
+	if err != nil {
+		return nil, xerrors.Errorf("h %s: %w", err, ErrInvalidOptions)
+	}
+
+	if fit := strings.ToLower(q.Get("fit")); fit != "" {
+		if fit != FitContain && fit != FitCover && fit != FitFill {
+			return nil, xerrors.Errorf("fit must be contain, cover or fill: %w", ErrInvalidOptions)
+		}
+		o.Fit = fit
+	}
+
+	if format := strings.ToLower(q.Get("format")); format != "" {
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if format != "png" && format != "jpeg" && format != "gif" {
+			return nil, xerrors.Errorf("format must be png, jpeg or gif: %w", ErrInvalidOptions)
+		}
+		o.Format = format
+	}
+
+	return o, nil
+}
+
+// Key returns a name for the rendition which is unique for these options,
+// it is used as the cache filename
+func (o *Options) Key() string {
+	format := o.Format
+	if format == "" {
+		format = "source"
+	}
+	return fmt.Sprintf("w%d-h%d-%s-%s", o.Width, o.Height, o.Fit, format)
+}
+
+// parseDimension parses an optional width or height, zero means not set
+func parseDimension(v string, max int) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	d, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, xerrors.New("must be a number")
+	}
+	if d < 1 || d > max {
+		return 0, xerrors.Errorf("must be between 1 and %d", max)
+	}
+
+	return d, nil
+}
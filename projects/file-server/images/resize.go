@@ -0,0 +1,153 @@
+package images
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrUnsupportedImage is returned when the source can't be decoded by the standard library
+var ErrUnsupportedImage = xerrors.New("Unsupported image format")
+
+// ErrImageTooLarge is returned when the source image has more pixels than we are willing to decode
+var ErrImageTooLarge = xerrors.New("Source image is too large")
+
+// maxSourcePixels bounds the size of images we decode, a small compressed file
+// can claim enormous dimensions and exhaust memory when decoded
+const maxSourcePixels = 50 * 1000 * 1000
+
+// Transform decodes the image read from src, resizes it according to the options
+// and encodes it to dst. It returns the format that was written
+func Transform(src io.ReadSeeker, o *Options, dst io.Writer) (string, error) {
+	// check the dimensions before decoding the whole image
+	cfg, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return "", xerrors.Errorf("%s: %w", err, ErrUnsupportedImage)
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return "", ErrImageTooLarge
+	}
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", xerrors.Errorf("Unable to read image: %w", err)
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return "", xerrors.Errorf("%s: %w", err, ErrUnsupportedImage)
+	}
+
+	out := Resize(img, o)
+
+	if o.Format != "" {
+		format = o.Format
+	}
+
+	switch format {
+	case "png":
+		err = png.Encode(dst, out)
+	case "jpeg":
+		err = jpeg.Encode(dst, out, &jpeg.Options{Quality: 85})
+	case "gif":
+		err = gif.Encode(dst, out, nil)
+	default:
+		return "", xerrors.Errorf("Format %s: %w", format, ErrUnsupportedImage)
+	}
+	if err != nil {
+		return "", xerrors.Errorf("Unable to encode image: %w", err)
+	}
+
+	return format, nil
+}
+
+// Resize returns the image scaled and cropped as described by the options,
+// when neither width nor height are given the image is returned unchanged
+func Resize(img image.Image, o *Options) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if o.Width == 0 && o.Height == 0 || sw == 0 || sh == 0 {
+		return img
+	}
+
+	// the part of the source we sample from, cover crops this down
+	crop := b
+	dw, dh := o.Width, o.Height
+
+	switch {
+	case dh == 0:
+		// only the width was given, keep the aspect ratio
+		dh = max(1, sh*dw/sw)
+	case dw == 0:
+		dw = max(1, sw*dh/sh)
+	case o.Fit == FitContain:
+		// shrink the box to the image's aspect ratio
+		if sw*dh > sh*dw {
+			dh = max(1, sh*dw/sw)
+		} else {
+			dw = max(1, sw*dh/sh)
+		}
+	case o.Fit == FitCover:
+		// crop the source to the box's aspect ratio around its centre
+		if sw*dh > sh*dw {
+			cw := sh * dw / dh
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := sw * dh / dw
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+	}
+
+	// work on RGBA so we can read pixels directly
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	crop = crop.Sub(b.Min)
+
+	return scale(rgba, crop, dw, dh)
+}
+
+// scale resamples the src rectangle r into a dw x dh image, each destination pixel
+// is the average of the source pixels it covers (a box filter) which gives decent
+// thumbnails when shrinking and falls back to nearest neighbour when enlarging
+func scale(src *image.RGBA, r image.Rectangle, dw, dh int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	rw, rh := r.Dx(), r.Dy()
+
+	for y := 0; y < dh; y++ {
+		sy0 := r.Min.Y + y*rh/dh
+		sy1 := max(sy0+1, r.Min.Y+(y+1)*rh/dh)
+
+		for x := 0; x < dw; x++ {
+			sx0 := r.Min.X + x*rw/dw
+			sx1 := max(sx0+1, r.Min.X+(x+1)*rw/dw)
+
+			var rs, gs, bs, as, n int
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					rs += int(src.Pix[i])
+					gs += int(src.Pix[i+1])
+					bs += int(src.Pix[i+2])
+					as += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(rs / n)
+			dst.Pix[j+1] = uint8(gs / n)
+			dst.Pix[j+2] = uint8(bs / n)
+			dst.Pix[j+3] = uint8(as / n)
+		}
+	}
+
+	return dst
+}
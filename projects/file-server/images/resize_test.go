@@ -0,0 +1,49 @@
+package images
+
+import (
+	"image"
+	"net/url"
+	"testing"
+)
+
+func TestResizeDimensions(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	tests := []struct {
+		o    Options
+		w, h int
+	}{
+		{Options{Width: 100, Fit: FitContain}, 100, 50},
+		{Options{Height: 50, Fit: FitContain}, 100, 50},
+		{Options{Width: 100, Height: 100, Fit: FitContain}, 100, 50},
+		{Options{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{Options{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+		{Options{}, 400, 200},
+	}
+
+	for _, tt := range tests {
+		b := Resize(src, &tt.o).Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("Resize(%+v) = %dx%d, want %dx%d", tt.o, b.Dx(), b.Dy(), tt.w, tt.h)
+		}
+	}
+}
+
+func TestParseOptionsBounds(t *testing.T) {
+	bad := []string{"w=0", "w=5000", "h=abc", "fit=squash", "format=bmp"}
+	for _, q := range bad {
+		v, _ := url.ParseQuery(q)
+		if _, err := ParseOptions(v, 2048); err == nil {
+			t.Errorf("ParseOptions(%q) expected error", q)
+		}
+	}
+
+	v, _ := url.ParseQuery("w=200&fit=cover&format=jpg")
+	o, err := ParseOptions(v, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Width != 200 || o.Fit != FitCover || o.Format != "jpeg" {
+		t.Fatalf("unexpected options %+v", o)
+	}
+}
@@ -2,28 +2,47 @@ package main
 
 import (
 	"context"
+	"errors"
 	"file-server/config"
+	"file-server/events"
 	"file-server/files"
 	"file-server/handlers"
+	"file-server/images"
+	"file-server/products"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
+	"shared/accesslog"
+	"shared/conf"
+	"shared/cors"
+	"shared/health"
+	"shared/lifecycle"
+	"shared/metrics"
+	"shared/trace"
+	"slices"
 	"time"
 
-	gohandlers "github.com/gorilla/handlers"
-
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
 )
 
 func main() {
-	// Load configuration from environment variables
-	cfg := config.LoadConfig()
+	// Load configuration from the config file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
 
 	// Get config values
-	var bindAddress = cfg.GetBindAddress()
-	var logLevel = cfg.GetLogLevel()
-	var basePath = cfg.GetBasePath()
+	var bindAddress = cfg.BindAddress
+	var logLevel = cfg.LogLevel
+	var basePath = cfg.BasePath
 
 	l := hclog.New(
 		&hclog.LoggerOptions{
@@ -35,91 +54,333 @@ func main() {
 	// create a logger for the server from the default logger
 	sl := l.StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true})
 
-	// create the storage class, use local storage
-	// max filesize 5MB
-	stor, err := files.NewLocalStorage(basePath, 1024*1000*5)
+	// create the storage class, local storage keeps files as named on disk,
+	// cas deduplicates identical content by storing it once under its SHA-256
+	var stor files.Storage
+	switch cfg.StorageMode {
+	case "local":
+		stor, err = files.NewLocalStorage(basePath, int(cfg.MaxFileSize))
+	case "cas":
+		stor, err = files.NewCASStorage(basePath, int(cfg.MaxFileSize))
+	}
 	if err != nil {
 		l.Error("Unable to create storage", "error", err)
 		os.Exit(1)
 	}
+	l.Info("Using storage", "mode", cfg.StorageMode, "base_path", basePath)
+
+	// enforce quotas per id and for the whole store, usage is counted once here
+	// and then kept up to date as files are saved and deleted
+	qs, err := files.NewQuotaStorage(stor, quotaLimits(cfg))
+	if err != nil {
+		l.Error("Unable to count storage usage", "error", err)
+		os.Exit(1)
+	}
+	stor = qs
+
+	// metadata such as the detected content type is kept in sidecars next to the files
+	meta, err := files.NewMetadataStore(basePath)
+	if err != nil {
+		l.Error("Unable to create metadata store", "error", err)
+		os.Exit(1)
+	}
+
+	// go run . scrub re-verifies the checksum of every stored file and exits
+	if args := cfg.Args(); len(args) > 0 && args[0] == "scrub" {
+		os.Exit(scrub(stor, meta, l))
+	}
+
+	// uploads are sniffed and must be one of the allowed types
+	// * accepts any type
+	var allowed []string
+	if !slices.Contains(cfg.AllowedTypes, "*") {
+		allowed = cfg.AllowedTypes
+	}
+	tc := files.NewTypeChecker(allowed)
+
+	// resized images are generated on demand and cached on disk
+	rc, err := images.NewCache(basePath)
+	if err != nil {
+		l.Error("Unable to create rendition cache", "error", err)
+		os.Exit(1)
+	}
+
+	// resumable uploads are staged on disk until they are finalized
+	up, err := files.NewUploads(basePath, int(cfg.MaxFileSize), cfg.UploadExpiry)
+	if err != nil {
+		l.Error("Unable to create upload staging area", "error", err)
+		os.Exit(1)
+	}
 
 	// create the handlers
-	fh := handlers.NewFiles(stor, l)
+	fh := handlers.NewFiles(stor, meta, tc, l).
+		WithRenditions(rc, cfg.MaxImageDimension).
+		WithUploads(up).
+		WithQuotas(qs).
+		WithMaxArchiveSize(int64(cfg.MaxArchiveSize))
+
+	// deleted files wait in the trash until the retention period ends
+	var trash *files.Trash
+	if cfg.TrashRetention > 0 {
+		trash, err = files.NewTrash(basePath, cfg.TrashRetention)
+		if err != nil {
+			l.Error("Unable to create trash", "error", err)
+			os.Exit(1)
+		}
+		fh.WithTrash(trash)
+	}
+
+	// file changes are streamed to /events and, when configured, sent to webhooks
+	var wh *events.Webhooks
+	if urls := cfg.Webhooks.URLs; len(urls) > 0 {
+		ob, err := events.NewOutbox(basePath)
+		if err != nil {
+			l.Error("Unable to create webhook outbox", "error", err)
+			os.Exit(1)
+		}
+		wh = events.NewWebhooks(urls, []byte(cfg.Webhooks.Secret), ob, l.Named("webhooks"))
+	}
+	es := events.NewStream()
+	fh.WithEvents(es, wh)
+
+	// uploads are only accepted for ids which are products in product-api
+	if u := cfg.Products.URL; u != "" {
+		fh.WithProducts(products.NewHTTPClient(u, cfg.Products.Timeout))
+	}
+
+	// overwritten files keep their prior content as numbered versions
+	if cfg.MaxVersions > 0 {
+		vs, err := files.NewVersions(basePath, cfg.MaxVersions)
+		if err != nil {
+			l.Error("Unable to create version store", "error", err)
+			os.Exit(1)
+		}
+		fh.WithVersions(vs)
+	}
+
+	// spans of requests, storage operations and product-api calls, continuing
+	// the trace of the caller's traceparent header
+	tr, err := trace.New(cfg.Tracing, "file-server", func(err error) {
+		l.Error("Unable to export spans", "error", err)
+	})
+	if err != nil {
+		l.Error("Unable to create tracer", "error", err)
+		os.Exit(1)
+	}
 
 	// create a new serve mux and register the handlers
 	sm := mux.NewRouter()
 
+	// trace every request, first so the other middleware runs inside its span
+	sm.Use(tr.Middleware)
+
+	// give every request an id, returned in X-Request-ID and added to the handlers'
+	// log lines, and write an access line per request to stdout. Requests matching
+	// no route are logged by the NotFoundHandler
+	var access *slog.Logger
+	if cfg.AccessLog.Enabled {
+		access, _ = accesslog.NewLogger(os.Stdout, cfg.AccessLog.Format, slog.LevelInfo)
+	}
+	al := accesslog.New(nil, access)
+	sm.Use(al.Middleware)
+	sm.NotFoundHandler = al.Middleware(http.NotFoundHandler())
+
+	// request counts, latencies and the bytes of file content transferred, inside
+	// the trace and access log but before the signed URL check so requests it
+	// rejects are counted too
+	//use curl http://localhost:9095/metrics
+	mr := metrics.NewRegistry()
+	sm.Use(metrics.NewHTTP(mr).Middleware)
+	uploaded := metrics.Transfer(mr.Counter("file_server_uploaded_bytes_total", "Bytes of file content received by uploads.").With(), nil)
+	downloaded := metrics.Transfer(nil, mr.Counter("file_server_downloaded_bytes_total", "Bytes of file content sent by downloads.").With())
+	sm.Handle("/metrics", mr).Methods(http.MethodGet)
+
+	// signed URLs let a browser upload or fetch one image without credentials,
+	// they are disabled while no signing key is set
+	//use curl -X POST http://localhost:9095/sign -H "Authorization: Bearer $adminKey" -d '{"method":"POST","path":"/images/1/photo.png","expires_in":"15m","max_size":1048576}'
+	su := handlers.NewSignedURLs(newSigner(cfg.Signing.Key), cfg.Signing.AdminKey, cfg.Signing.Required, l).
+		WithTarget(fh.SignedTarget)
+	sm.Use(su.Middleware)
+	sm.HandleFunc("/sign", su.Sign).Methods(http.MethodPost)
+
 	// filename regex: {filename:[a-zA-Z]+\\.[a-z]{3}}
 	// problem with FileServer is that it is dumb.
 	ph := sm.Methods(http.MethodPost).Subrouter()
+	ph.Use(fh.MiddlewareProductExists)
+	ph.Use(uploaded)
 	ph.HandleFunc("/images/{id:[0-9]+}/{filename}", fh.ServeHTTP)
 
+	// bulk upload every file in a zip, tar or tar.gz archive to the id
+	//use curl http://localhost:9095/images/1.zip --data-binary @photos.zip
+	ph.HandleFunc(`/images/{id:[0-9]+}.{format:zip|tar|tar\.gz}`, fh.UploadArchive)
+
+	// multipart/form-data uploads, every file in the form is saved under the id
+	// use curl http://localhost:9095/images/1 -F file=@a.png -F file=@b.png
+	ph.HandleFunc("/images/{id:[0-9]+}", fh.UploadMultipart)
+
 	// get files
-	//use this curl to upload file-  curl http://localhost:9095/images/1/file-copied.txt -d @file-to-copy.txt
+	//use this curl to upload file-  curl http://localhost:9095/images/1/photo.png --data-binary @photo.png
 	gh := sm.Methods(http.MethodGet).Subrouter()
 
-	//using FileServer hander here, because that is already available. Trimming prefix /images because
-	//we have given the base path and inside base path we have id and then file name, there is no /images in base folder
-	//use curl http://localhost:9095/images/1/file-postman.txt to get the file content
-	gh.Handle(
-		"/images/{id:[0-9]+}/{filename}",
-		http.StripPrefix("/images/", http.FileServer(http.Dir(basePath))),
-	)
+	//use curl http://localhost:9095/images/1/photo.png to get the file content
+	//add ?w=200&h=200&fit=cover&format=jpeg to get a resized rendition
+	gh.Handle("/images/{id:[0-9]+}/{filename}", downloaded(http.HandlerFunc(fh.GetFile)))
+
+	// download every file of an id as an archive
+	//use curl -O http://localhost:9095/images/1.zip or http://localhost:9095/images/1.tar.gz
+	gh.Handle(`/images/{id:[0-9]+}.{format:zip|tar\.gz}`, downloaded(http.HandlerFunc(fh.DownloadArchive)))
+
+	// list the files of one product id, takes the same query parameters as /files
+	gh.HandleFunc("/images/{id:[0-9]+}", fh.ListProductFiles)
+
+	// space used by an id and its quota, outside /images/{id} where a file could be named usage
+	//use curl http://localhost:9095/usage/1
+	gh.HandleFunc("/usage/{id:[0-9]+}", fh.Usage)
 
-	// Health check endpoint
+	// file metadata, description and labels can be edited with PUT
+	//use curl -X PUT http://localhost:9095/images/1/photo.png/meta -d '{"description":"front","labels":{"color":"red"}}'
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename}/meta", fh.GetMetadata).Methods(http.MethodGet)
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename}/meta", fh.UpdateMetadata).Methods(http.MethodPut)
+
+	// prior versions of a file, only when versioning is enabled
+	//use curl -X POST http://localhost:9095/images/1/photo.png/versions/2/rollback
+	if cfg.MaxVersions > 0 {
+		sm.HandleFunc("/images/{id:[0-9]+}/{filename}/versions", fh.ListVersions).Methods(http.MethodGet)
+		sm.HandleFunc("/images/{id:[0-9]+}/{filename}/versions/{version:[0-9]+}/rollback", fh.RollbackVersion).Methods(http.MethodPost)
+		sm.HandleFunc("/images/{id:[0-9]+}/{filename}/versions/{version:[0-9]+}", fh.DeleteVersion).Methods(http.MethodDelete)
+	}
+
+	// liveness and readiness for load balancers and orchestrators, readiness
+	// checks the storage can be written to and fails once shutdown starts
+	//use curl http://localhost:9095/readyz
+	hr := health.NewRegistry("file-server", 2*time.Second)
+	hr.Register("storage", health.DirWritable(basePath))
+	sm.HandleFunc("/livez", hr.Live).Methods(http.MethodGet)
+	sm.HandleFunc("/readyz", hr.Ready).Methods(http.MethodGet)
+
+	// Health check endpoint, kept for existing clients, prefer /livez and /readyz
 	sm.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status": "healthy", "service": "file-server"}`))
 	}).Methods(http.MethodGet)
 
-	// List all files endpoint
+	// List all files endpoint, filter with id, name, min_size, max_size, modified_after,
+	// modified_before, order with sort and page with limit and cursor
+	//use curl "http://localhost:9095/files?name=*.png&sort=-size&limit=20"
 	sm.HandleFunc("/files", fh.ListFiles).Methods(http.MethodGet)
 
+	// file changes as Server-Sent Events, ?id= limits them to one product id
+	//use curl -N http://localhost:9095/events
+	sm.HandleFunc("/events", fh.Events).Methods(http.MethodGet)
+
+	// resumable uploads, see handlers/uploads.go for the protocol
+	//use curl -X POST http://localhost:9095/uploads/1/photo.png -H "Upload-Length: 1048576"
+	sm.Handle("/uploads/{id:[0-9]+}/{filename}", fh.MiddlewareProductExists(http.HandlerFunc(fh.CreateUpload))).Methods(http.MethodPost)
+	sm.HandleFunc("/uploads/{upload:[0-9a-f]+}", fh.UploadStatus).Methods(http.MethodHead)
+	sm.Handle("/uploads/{upload:[0-9a-f]+}", uploaded(http.HandlerFunc(fh.AppendUpload))).Methods(http.MethodPatch)
+	sm.HandleFunc("/uploads/{upload:[0-9a-f]+}/finalize", fh.FinalizeUpload).Methods(http.MethodPost)
+	sm.HandleFunc("/uploads/{upload:[0-9a-f]+}", fh.AbortUpload).Methods(http.MethodDelete)
+
+	// deleted files, only when the trash is enabled
+	//use curl -X POST http://localhost:9095/trash/{trash}/restore
+	if trash != nil {
+		sm.HandleFunc("/trash", fh.ListTrash).Methods(http.MethodGet)
+		sm.HandleFunc("/trash/{trash:[0-9a-f]+}/restore", fh.RestoreTrash).Methods(http.MethodPost)
+		sm.HandleFunc("/trash/{trash:[0-9a-f]+}", fh.PurgeTrash).Methods(http.MethodDelete)
+	}
+
 	// Delete file endpoint
 	dh := sm.Methods(http.MethodDelete).Subrouter()
 	dh.HandleFunc("/images/{id:[0-9]+}/{filename}", fh.DeleteFile)
 
-	//CORS middleware to allow cross-origin requests from browsers
-	ch := gohandlers.CORS(
-		// allow all origins, methods, and headers
-		gohandlers.AllowedOrigins([]string{"*"}),
-		gohandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		//allowed 2 headers for requests
-		gohandlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-	)
+	//CORS middleware to allow cross-origin requests from browsers, the origins,
+	// credentials, extra exposed headers and max age come from the config
+	cp := cors.NewPolicy(cfg.CORS, cors.Options{
+		Methods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		//allowed headers for requests, including the upload and checksum headers
+		Headers: []string{
+			"Content-Type", "Authorization", "Content-MD5", "Digest", "X-Uploader",
+			"Upload-Length", "Upload-Offset",
+		},
+		// let browsers read the paging, checksum and upload headers
+		ExposedHeaders: []string{
+			"X-Next-Cursor", "Link", "Digest", "Location", "Upload-Offset", "Upload-Length",
+		},
+	})
 
 	// create a new server
 	s := http.Server{
-		Addr:         bindAddress,       // configure the bind address
-		Handler:      ch(sm),            // set the default handler
-		ErrorLog:     sl,                // the logger for the server
-		ReadTimeout:  5 * time.Second,   // max time to read request from the client
-		WriteTimeout: 10 * time.Second,  // max time to write response to the client
-		IdleTimeout:  120 * time.Second, // max time for connections using TCP Keep-Alive
+		Addr:         bindAddress,             // configure the bind address
+		Handler:      cp.Handler(sm),          // set the default handler
+		ErrorLog:     sl,                      // the logger for the server
+		ReadTimeout:  cfg.Server.ReadTimeout,  // max time to read request from the client
+		WriteTimeout: cfg.Server.WriteTimeout, // max time to write response to the client
+		IdleTimeout:  cfg.Server.IdleTimeout,  // max time for connections using TCP Keep-Alive
 	}
 
-	// start the server
-	go func() {
-		l.Info("Starting server", "bind_address", bindAddress)
+	// end the event streams when shutting down, they would otherwise hold it up
+	s.RegisterOnShutdown(es.Close)
+
+	// the server and its background work, run until SIGINT or SIGTERM
+	rn := lifecycle.New(&s, l).
+		WithTimeout(cfg.Server.ShutdownTimeout).
+		// fail readiness so no new traffic is sent while we drain
+		OnDrain(hr.Shutdown)
 
-		err := s.ListenAndServe()
+	// remove resumable uploads which were abandoned
+	rn.Go("upload expiry", lifecycle.Every(min(cfg.UploadExpiry, time.Hour), func(context.Context) {
+		n, err := up.Expire()
 		if err != nil {
-			l.Error("Unable to start server", "error", err)
-			os.Exit(1)
+			l.Error("Unable to expire uploads", "error", err)
+			return
 		}
-	}()
+		if n > 0 {
+			l.Info("Expired abandoned uploads", "count", n)
+		}
+	}))
 
-	// trap sigterm or interupt and gracefully shutdown the server
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	signal.Notify(c, os.Kill)
+	// send queued webhooks, including any left over from before a restart
+	if wh != nil {
+		rn.Go("webhooks", func(ctx context.Context) error {
+			wh.Run(ctx)
+			return nil
+		})
+	}
+
+	// permanently remove files which have been in the trash too long
+	if trash != nil {
+		rn.Go("trash purge", lifecycle.Every(min(cfg.TrashRetention, time.Hour), func(context.Context) {
+			n, err := trash.Purge()
+			if err != nil {
+				l.Error("Unable to purge trash", "error", err)
+				return
+			}
+			if n > 0 {
+				l.Info("Purged expired trash", "count", n)
+			}
+		}))
+	}
+
+	// apply config changes on SIGHUP or when the config file changes
+	rl := &reloader{
+		args:     os.Args[1:],
+		cur:      cfg,
+		log:      l,
+		cors:     cp,
+		signed:   su,
+		quotas:   qs,
+		webhooks: wh,
+	}
+	rn.Go("config watcher", func(ctx context.Context) error {
+		conf.Watch(ctx, cfg.File(), configPollInterval, rl.reload)
+		return nil
+	})
 
-	// Block until a signal is received.
-	sig := <-c
-	l.Info("Shutting down server with", "signal", sig)
+	// send the spans still queued once requests are drained and workers stopped
+	rn.OnClose("tracer", tr.Shutdown)
 
-	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	s.Shutdown(ctx)
+	// serve until a signal, then shut down in order, exiting with 1 when the
+	// server fails or shutting down doesn't complete within the timeout
+	os.Exit(rn.Run(context.Background()))
 }
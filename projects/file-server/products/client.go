@@ -0,0 +1,65 @@
+package products
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"shared/accesslog"
+	"shared/trace"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrUnavailable is returned when product-api can't answer whether a product exists
+var ErrUnavailable = xerrors.New("Product API unavailable")
+
+// Client looks up products in product-api
+type Client interface {
+	// Exists reports whether the product with the given id exists
+	Exists(ctx context.Context, id string) (bool, error)
+}
+
+// HTTPClient is a Client calling product-api's GET /product/{id}
+type HTTPClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPClient creates a Client for the product-api at baseURL, e.g. http://localhost:9080,
+// calls pass on the trace and request id of the request they are made for
+func NewHTTPClient(baseURL string, timeout time.Duration) *HTTPClient {
+	return &HTTPClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout, Transport: &accesslog.Transport{Base: &trace.Transport{}}},
+	}
+}
+
+// Exists asks product-api for the product, a 404 means it doesn't exist and
+// any other failure is ErrUnavailable
+func (c *HTTPClient) Exists(ctx context.Context, id string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/product/"+url.PathEscape(id), nil)
+	if err != nil {
+		return false, xerrors.Errorf("Unable to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, xerrors.Errorf("%s: %w", err, ErrUnavailable)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, xerrors.Errorf("Product %s returned status %d: %w", id, resp.StatusCode, ErrUnavailable)
+	}
+}
@@ -0,0 +1,54 @@
+package products
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestHTTPClientExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/product/1":
+			rw.Write([]byte(`{"id":1}`))
+		case "/product/2":
+			http.NotFound(rw, r)
+		default:
+			http.Error(rw, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHTTPClient(srv.URL+"/", time.Second)
+
+	tests := []struct {
+		id     string
+		exists bool
+		err    error
+	}{
+		{"1", true, nil},
+		{"2", false, nil},
+		{"3", false, ErrUnavailable},
+	}
+
+	for _, tt := range tests {
+		exists, err := c.Exists(context.Background(), tt.id)
+		if exists != tt.exists || !xerrors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.id, tt.exists, tt.err, exists, err)
+		}
+	}
+}
+
+func TestHTTPClientUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := NewHTTPClient(srv.URL, time.Second).Exists(context.Background(), "1")
+	if !xerrors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
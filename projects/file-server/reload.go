@@ -0,0 +1,99 @@
+package main
+
+import (
+	"file-server/config"
+	"file-server/events"
+	"file-server/files"
+	"file-server/handlers"
+	"shared/conf"
+	"shared/cors"
+	"shared/signing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloader applies the settings tagged reload:"true" in config.Config to the
+// running server when the config is reloaded, other changes are logged as
+// needing a restart
+type reloader struct {
+	args []string
+	cur  *config.Config
+	log  hclog.Logger
+
+	cors     *cors.Policy
+	signed   *handlers.SignedURLs
+	quotas   *files.QuotaStorage
+	webhooks *events.Webhooks // nil without webhook URLs
+}
+
+// reload loads the config again, an invalid config is rejected and the current
+// one kept. It is called by conf.Watch, never concurrently
+func (r *reloader) reload(reason string) {
+	cfg, err := config.Load(r.args)
+	if err != nil {
+		r.log.Error("Rejected config reload, keeping the current config", "reason", reason, "error", err)
+		return
+	}
+
+	changes := conf.Diff(r.cur, cfg)
+	if len(changes) == 0 {
+		r.log.Info("Config reloaded, nothing changed", "reason", reason)
+		return
+	}
+
+	// the new reloadable settings must also be valid next to the restart-only
+	// settings still running, e.g. a webhook secret can't be removed while the
+	// running server keeps sending to its URLs
+	next := *r.cur
+	conf.CopyReloadable(&next, cfg)
+	if err := next.Validate(); err != nil {
+		r.log.Error("Rejected config reload, keeping the current config", "reason", reason, "error", err)
+		return
+	}
+
+	for _, c := range changes {
+		if !c.Reload {
+			r.log.Warn("Config change needs a restart, ignoring it", "setting", c.Key, "old", c.Old, "new", c.New)
+			continue
+		}
+		r.log.Info("Config changed", "setting", c.Key, "old", c.Old, "new", c.New)
+	}
+
+	r.apply(&next)
+	r.cur = &next
+
+	r.log.Info("Config reloaded", "reason", reason)
+}
+
+// apply swaps the reloadable settings of cfg into the running server
+func (r *reloader) apply(cfg *config.Config) {
+	r.log.SetLevel(hclog.LevelFromString(cfg.LogLevel))
+	r.cors.Set(cfg.CORS)
+	r.signed.SetKeys(newSigner(cfg.Signing.Key), cfg.Signing.AdminKey, cfg.Signing.Required)
+	r.quotas.SetLimits(quotaLimits(cfg))
+	if r.webhooks != nil {
+		r.webhooks.SetSecret([]byte(cfg.Webhooks.Secret))
+	}
+}
+
+// newSigner returns the signer for key, nil when signed URLs are disabled
+func newSigner(key string) *signing.Signer {
+	if key == "" {
+		return nil
+	}
+	return signing.NewSigner([]byte(key))
+}
+
+// quotaLimits returns the quotas set in cfg
+func quotaLimits(cfg *config.Config) files.QuotaLimits {
+	return files.QuotaLimits{
+		IDBytes:    int64(cfg.Quotas.IDBytes),
+		IDFiles:    cfg.Quotas.IDFiles,
+		TotalBytes: int64(cfg.Quotas.TotalBytes),
+		TotalFiles: cfg.Quotas.TotalFiles,
+	}
+}
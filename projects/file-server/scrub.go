@@ -0,0 +1,46 @@
+package main
+
+import (
+	"file-server/files"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// scrub verifies every stored file against the checksum recorded when it was uploaded
+// it returns the exit code, 1 when any file is corrupt or can't be read
+func scrub(stor files.Storage, meta *files.MetadataStore, l hclog.Logger) int {
+	l.Info("Scrubbing storage")
+
+	results, err := files.Scrub(stor, meta)
+	if err != nil {
+		l.Error("Unable to scrub storage", "error", err)
+		return 1
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+
+		switch r.Status {
+		case files.ScrubOK:
+			l.Debug("File verified", "path", r.Path)
+		case files.ScrubUnverified:
+			l.Warn("File has no recorded checksum", "path", r.Path)
+		default:
+			l.Error("File failed verification", "path", r.Path, "status", r.Status, "expected", r.Expected, "actual", r.Actual)
+		}
+	}
+
+	l.Info("Scrub complete",
+		"files", len(results),
+		files.ScrubOK, counts[files.ScrubOK],
+		files.ScrubUnverified, counts[files.ScrubUnverified],
+		files.ScrubCorrupt, counts[files.ScrubCorrupt],
+		files.ScrubUnreadable, counts[files.ScrubUnreadable],
+	)
+
+	if counts[files.ScrubCorrupt] > 0 || counts[files.ScrubUnreadable] > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,62 +1,160 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
+	"time"
+
+	"shared/accesslog"
+	"shared/conf"
+	"shared/cors"
+	"shared/trace"
 )
 
-// AppConfig holds application configuration
+// FileEnv names the environment variable holding the path of an optional YAML config file
+const FileEnv = "PRODUCT_API_CONFIG"
+
+// AppConfig holds application configuration. Settings come from the defaults, a
+// YAML config file named by -config or PRODUCT_API_CONFIG, environment variables
+// and flags, each overriding the one before. Flags are the environment variable
+// in lower case with - for _, e.g. -db-host, and any variable can be read from
+// a file by appending _FILE to its name, e.g. DB_PASSWORD_FILE.
+//
+// Settings tagged reload:"true" are applied without a restart when the process
+// receives SIGHUP or the config file changes
 type AppConfig struct {
-	DatabaseConfig DatabaseConfig
-	ServerConfig   ServerConfig
+	DatabaseConfig   DatabaseConfig   `yaml:"database" env:"DB"`
+	ServerConfig     ServerConfig     `yaml:"server" env:"SERVER"`
+	FileServerConfig FileServerConfig `yaml:"file_server" env:"FILE_SERVER"`
+	CORSConfig       cors.Config      `yaml:"cors" env:"CORS"`
+	TracingConfig    trace.Config     `yaml:"tracing" env:"TRACING"`
+	AccessLogConfig  accesslog.Config `yaml:"access_log" env:"ACCESS_LOG"`
+	LogConfig        LogConfig        `yaml:"log" env:"LOG"`
+
+	// the config file read, empty when there was none
+	file string
 }
 
 // DatabaseConfig holds database connection parameters
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	Host     string `yaml:"host" env:"HOST" default:"localhost" required:"true" usage:"PostgreSQL host"`
+	Port     int    `yaml:"port" env:"PORT" default:"5436" usage:"PostgreSQL port"`
+	User     string `yaml:"user" env:"USER" default:"postgres" required:"true" usage:"PostgreSQL user"`
+	Password string `yaml:"password" env:"PASSWORD" default:"admin" secret:"true" usage:"PostgreSQL password"`
+	DBName   string `yaml:"name" env:"NAME" default:"product_api" required:"true" usage:"database name"`
+	SSLMode  string `yaml:"ssl_mode" env:"SSL_MODE" default:"disable" usage:"PostgreSQL sslmode"`
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Port int
+	Port int `yaml:"port" env:"PORT" default:"9080" usage:"port the API listens on"`
+}
+
+// FileServerConfig holds where to find the file-server holding product images
+type FileServerConfig struct {
+	// URL the API calls the file-server on
+	URL string `yaml:"url" env:"URL" default:"http://localhost:9095" required:"true" usage:"file-server the API lists product images from"`
+	// PublicURL is used in image links given to clients, defaults to URL
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL" usage:"base of image URLs given to clients, defaults to the file-server URL"`
+	// Timeout is how long to wait for the file-server
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" default:"5s" usage:"max time to wait for the file-server"`
+	// SigningKey is the file-server's signing.key, needed when it has signing.required on
+	SigningKey string `yaml:"signing_key" env:"SIGNING_KEY" secret:"true" usage:"file-server signing.key to sign listings and image URLs with, empty sends them unsigned"`
+	// URLExpiry is how long the signed image URLs given to clients stay valid
+	URLExpiry time.Duration `yaml:"url_expiry" env:"URL_EXPIRY" default:"1h" usage:"how long signed image URLs stay valid"`
+}
+
+// LogConfig holds how the API logs, the access log is configured separately
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LEVEL" default:"info" reload:"true" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"FORMAT" default:"logfmt" usage:"format of the logs, logfmt or json"`
 }
 
-// LoadConfig loads configuration from environment variables with defaults
-func LoadConfig() *AppConfig {
-	return &AppConfig{
-		DatabaseConfig: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvAsInt("DB_PORT", 5436),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "admin"),
-			DBName:   getEnv("DB_NAME", "product_api"),
-			SSLMode:  getEnv("DB_SSL_MODE", "disable"),
-		},
-		ServerConfig: ServerConfig{
-			Port: getEnvAsInt("SERVER_PORT", 9080),
-		},
+// SlogLevel returns Level as a slog.Level, info when it is invalid
+func (c LogConfig) SlogLevel() slog.Level {
+	var l slog.Level
+	if l.UnmarshalText([]byte(c.Level)) != nil {
+		return slog.LevelInfo
 	}
+	return l
 }
 
-// getEnv gets environment variable or returns default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// LoadConfig loads configuration from the defaults, config file, environment variables
+// and the command line args, returning every invalid setting in the error
+func LoadConfig(args []string) (*AppConfig, error) {
+	cfg := &AppConfig{}
+
+	src, err := conf.Load(cfg, conf.Options{
+		Name:    "product-api",
+		FileEnv: FileEnv,
+		Args:    args,
+		Usage:   os.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.file = src.File
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
 	}
-	return defaultValue
+
+	return cfg, nil
 }
 
-// getEnvAsInt gets environment variable as integer or returns default value
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+// File returns the path of the config file read, empty when there was none
+func (c *AppConfig) File() string {
+	return c.file
+}
+
+// Validate checks the settings and returns all the problems found
+func (c *AppConfig) Validate() error {
+	var errs []error
+
+	if c.DatabaseConfig.Port < 1 || c.DatabaseConfig.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database.port: must be between 1 and 65535, got %d", c.DatabaseConfig.Port))
 	}
-	return defaultValue
+	if c.ServerConfig.Port < 1 || c.ServerConfig.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port: must be between 1 and 65535, got %d", c.ServerConfig.Port))
+	}
+	if !validURL(c.FileServerConfig.URL) {
+		errs = append(errs, fmt.Errorf("file_server.url: %q is not an http or https URL", c.FileServerConfig.URL))
+	}
+	if c.FileServerConfig.PublicURL != "" && !validURL(c.FileServerConfig.PublicURL) {
+		errs = append(errs, fmt.Errorf("file_server.public_url: %q is not an http or https URL", c.FileServerConfig.PublicURL))
+	}
+	if c.FileServerConfig.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("file_server.timeout: must be greater than 0"))
+	}
+	if c.FileServerConfig.SigningKey != "" && c.FileServerConfig.URLExpiry <= 0 {
+		errs = append(errs, fmt.Errorf("file_server.url_expiry: must be greater than 0 with a signing key"))
+	}
+	if err := c.CORSConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.TracingConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.AccessLogConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, c.LogConfig.Level) {
+		errs = append(errs, fmt.Errorf("log.level: must be debug, info, warn or error, got %q", c.LogConfig.Level))
+	}
+	if !slices.Contains(accesslog.Formats, c.LogConfig.Format) {
+		errs = append(errs, fmt.Errorf("log.format: must be one of %v, got %q", accesslog.Formats, c.LogConfig.Format))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validURL reports whether s is an absolute http or https URL
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
 }
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DatabaseConfig.Host != "localhost" || cfg.DatabaseConfig.Port != 5436 || cfg.ServerConfig.Port != 9080 {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if cfg.FileServerConfig.URL != "http://localhost:9095" || cfg.FileServerConfig.Timeout != 5*time.Second {
+		t.Errorf("unexpected file-server defaults %+v", cfg.FileServerConfig)
+	}
+	if cfg.LogConfig.SlogLevel() != slog.LevelInfo || cfg.LogConfig.Format != "logfmt" {
+		t.Errorf("unexpected log defaults %+v", cfg.LogConfig)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	pw := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(pw, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DB_HOST", "db.internal")
+	t.Setenv("DB_PORT", "5432")
+	t.Setenv("DB_NAME", "products")
+	t.Setenv("DB_SSL_MODE", "require")
+	t.Setenv("DB_PASSWORD_FILE", pw)
+	t.Setenv("SERVER_PORT", "8000")
+	t.Setenv("FILE_SERVER_PUBLIC_URL", "https://images.example.com")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://shop.example.com, https://*.admin.example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DatabaseConfig{Host: "db.internal", Port: 5432, User: "postgres", Password: "s3cret", DBName: "products", SSLMode: "require"}
+	if cfg.DatabaseConfig != want {
+		t.Errorf("expected %+v, got %+v", want, cfg.DatabaseConfig)
+	}
+	if cfg.ServerConfig.Port != 8000 || cfg.FileServerConfig.PublicURL != "https://images.example.com" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if len(cfg.CORSConfig.AllowedOrigins) != 2 || !cfg.CORSConfig.AllowCredentials {
+		t.Errorf("unexpected CORS config %+v", cfg.CORSConfig)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	t.Setenv("DB_NAME", "")
+	t.Setenv("SERVER_PORT", "70000")
+
+	_, err := LoadConfig([]string{"-file-server-url", "localhost:9095"})
+	if err == nil || !strings.Contains(err.Error(), "database.name") {
+		t.Fatalf("expected the empty database name to be reported, got %v", err)
+	}
+
+	t.Setenv("DB_NAME", "products")
+	t.Setenv("TRACING_EXPORTER", "file")
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("LOG_FORMAT", "xml")
+	_, err = LoadConfig([]string{"-file-server-url", "localhost:9095"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, w := range []string{"server.port", "file_server.url", "tracing.path", "log.level", "log.format"} {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("expected %q in error %q", w, err)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/go-playground/validator"
 	"io"
 	"regexp"
+	"shared/accesslog"
+	"shared/trace"
+	"strings"
 )
 
 // swagger:model
@@ -86,7 +90,7 @@ func InitializeRepository(db *sql.DB) {
 }
 
 // GetProducts retrieves all products from the database
-func GetProducts() (Products, error) {
+func GetProducts(ctx context.Context) (Products, error) {
 	if productRepo == nil {
 		return nil, fmt.Errorf("product repository not initialized")
 	}
@@ -97,8 +101,12 @@ func GetProducts() (Products, error) {
 		WHERE deleted_at IS NULL 
 		ORDER BY id`
 
-	rows, err := productRepo.db.Query(query)
+	ctx, span := startQuery(ctx, "SELECT", query)
+	defer span.End()
+
+	rows, err := productRepo.db.QueryContext(ctx, query)
 	if err != nil {
+		span.RecordError(err)
 		return nil, fmt.Errorf("failed to query products: %w", err)
 	}
 	defer rows.Close()
@@ -117,20 +125,24 @@ func GetProducts() (Products, error) {
 			&p.DeletedAt,
 		)
 		if err != nil {
+			span.RecordError(err)
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
 		products = append(products, &p)
 	}
 
 	if err = rows.Err(); err != nil {
+		span.RecordError(err)
 		return nil, fmt.Errorf("error iterating products: %w", err)
 	}
+	span.SetAttributes(trace.Int("db.response.returned_rows", len(products)))
+	accesslog.Logger(ctx).Debug("Listed products", "count", len(products))
 
 	return products, nil
 }
 
 // AddProduct adds a new product to the database
-func AddProduct(p *Product) error {
+func AddProduct(ctx context.Context, p *Product) error {
 	if productRepo == nil {
 		return fmt.Errorf("product repository not initialized")
 	}
@@ -140,21 +152,26 @@ func AddProduct(p *Product) error {
 		VALUES ($1, $2, $3, $4) 
 		RETURNING id, created_at, updated_at`
 
-	err := productRepo.db.QueryRow(query, p.Name, p.Description, p.Price, p.SKU).Scan(
+	ctx, span := startQuery(ctx, "INSERT", query)
+	defer span.End()
+
+	err := productRepo.db.QueryRowContext(ctx, query, p.Name, p.Description, p.Price, p.SKU).Scan(
 		&p.ID,
 		&p.CreatedAt,
 		&p.UpdatedAt,
 	)
 
 	if err != nil {
+		span.RecordError(err)
 		return fmt.Errorf("failed to insert product: %w", err)
 	}
+	accesslog.Logger(ctx).Debug("Inserted product", "product_id", p.ID, "sku", p.SKU)
 
 	return nil
 }
 
 // UpdateProduct updates an existing product in the database
-func UpdateProduct(id int, p *Product) error {
+func UpdateProduct(ctx context.Context, id int, p *Product) error {
 	if productRepo == nil {
 		return fmt.Errorf("product repository not initialized")
 	}
@@ -165,21 +182,28 @@ func UpdateProduct(id int, p *Product) error {
 		WHERE id = $5 AND deleted_at IS NULL 
 		RETURNING updated_at`
 
-	err := productRepo.db.QueryRow(query, p.Name, p.Description, p.Price, p.SKU, id).Scan(&p.UpdatedAt)
+	ctx, span := startQuery(ctx, "UPDATE", query)
+	defer span.End()
 
+	err := productRepo.db.QueryRowContext(ctx, query, p.Name, p.Description, p.Price, p.SKU, id).Scan(&p.UpdatedAt)
+
+	l := accesslog.Logger(ctx).With("product_id", id, "sku", p.SKU)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			l.Debug("No product to update")
 			return ErrProductNotFound
 		}
+		span.RecordError(err)
 		return fmt.Errorf("failed to update product: %w", err)
 	}
+	l.Debug("Updated product")
 
 	p.ID = id
 	return nil
 }
 
 // FindProduct finds a product by ID
-func FindProduct(id int) (*Product, error) {
+func FindProduct(ctx context.Context, id int) (*Product, error) {
 	if productRepo == nil {
 		return nil, fmt.Errorf("product repository not initialized")
 	}
@@ -189,8 +213,11 @@ func FindProduct(id int) (*Product, error) {
 		FROM products 
 		WHERE id = $1 AND deleted_at IS NULL`
 
+	ctx, span := startQuery(ctx, "SELECT", query)
+	defer span.End()
+
 	var p Product
-	err := productRepo.db.QueryRow(query, id).Scan(
+	err := productRepo.db.QueryRowContext(ctx, query, id).Scan(
 		&p.ID,
 		&p.Name,
 		&p.Description,
@@ -203,16 +230,19 @@ func FindProduct(id int) (*Product, error) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
+			accesslog.Logger(ctx).Debug("Product not found", "product_id", id)
 			return nil, ErrProductNotFound
 		}
+		span.RecordError(err)
 		return nil, fmt.Errorf("failed to find product: %w", err)
 	}
+	accesslog.Logger(ctx).Debug("Found product", "product_id", id, "sku", p.SKU)
 
 	return &p, nil
 }
 
 // DeleteProduct soft deletes a product (sets deleted_at timestamp)
-func DeleteProduct(id int) error {
+func DeleteProduct(ctx context.Context, id int) error {
 	if productRepo == nil {
 		return fmt.Errorf("product repository not initialized")
 	}
@@ -222,21 +252,39 @@ func DeleteProduct(id int) error {
 		SET deleted_at = CURRENT_TIMESTAMP 
 		WHERE id = $1 AND deleted_at IS NULL`
 
-	result, err := productRepo.db.Exec(query, id)
+	ctx, span := startQuery(ctx, "UPDATE", query)
+	defer span.End()
+
+	result, err := productRepo.db.ExecContext(ctx, query, id)
 	if err != nil {
+		span.RecordError(err)
 		return fmt.Errorf("failed to delete product: %w", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
+		span.RecordError(err)
 		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
 	if rowsAffected == 0 {
+		accesslog.Logger(ctx).Debug("No product to delete", "product_id", id)
 		return ErrProductNotFound
 	}
+	accesslog.Logger(ctx).Debug("Deleted product", "product_id", id)
 
 	return nil
 }
 
 var ErrProductNotFound = fmt.Errorf("Product not found")
+
+// startQuery starts a span around a query on the products table, a child of the
+// request's span in ctx
+func startQuery(ctx context.Context, op, query string) (context.Context, *trace.Span) {
+	return trace.Start(ctx, op+" products", trace.KindClient,
+		trace.String("db.system.name", "postgresql"),
+		trace.String("db.operation.name", op),
+		trace.String("db.collection.name", "products"),
+		trace.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+	)
+}
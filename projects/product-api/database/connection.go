@@ -3,30 +3,23 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"product-api/config"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
 type DB struct {
 	*sql.DB
-}
 
-// Config holds database configuration
-type Config struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	l *slog.Logger
 }
 
-// NewConnection creates a new database connection
-func NewConnection(config Config) (*DB, error) {
+// NewConnection creates a new database connection, logging to l
+func NewConnection(cfg config.DatabaseConfig, l *slog.Logger) (*DB, error) {
 	// Create connection string
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode)
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
 
 	// Open database connection
 	db, err := sql.Open("postgres", psqlInfo)
@@ -39,9 +32,10 @@ func NewConnection(config Config) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("Successfully connected to PostgreSQL database")
+	l = l.With("db_host", cfg.Host, "db_port", cfg.Port, "db_name", cfg.DBName)
+	l.Info("Successfully connected to PostgreSQL database")
 
-	return &DB{db}, nil
+	return &DB{DB: db, l: l}, nil
 }
 
 // CreateTables creates the necessary tables if they don't exist
@@ -76,7 +70,7 @@ func (db *DB) CreateTables() error {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
 
-	log.Println("Database tables created successfully")
+	db.l.Info("Database tables created successfully")
 	return nil
 }
 
@@ -0,0 +1,124 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shared/accesslog"
+	"shared/signing"
+	"shared/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image is a product image stored in the file-server
+type Image struct {
+	Filename    string    `json:"filename"`
+	URL         string    `json:"url"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Client looks up the images the file-server holds for a product
+type Client interface {
+	// Images returns the images of the product with the given id
+	Images(ctx context.Context, productID int) ([]Image, error)
+}
+
+// HTTPClient is a Client calling the file-server's GET /images/{id}
+type HTTPClient struct {
+	baseURL   string
+	publicURL string
+	client    *http.Client
+	signer    *signing.Signer
+	expiry    time.Duration
+}
+
+// NewHTTPClient creates a Client for the file-server at baseURL.
+// Image URLs are built on publicURL, the address browsers reach the file-server on,
+// which is baseURL when empty. Calls pass on the trace of the request they are made for
+func NewHTTPClient(baseURL, publicURL string, timeout time.Duration) *HTTPClient {
+	if publicURL == "" {
+		publicURL = baseURL
+	}
+
+	return &HTTPClient{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		client:    &http.Client{Timeout: timeout, Transport: &accesslog.Transport{Base: &trace.Transport{}}},
+	}
+}
+
+// WithSigner signs the listing calls and the image URLs with s, for a file-server
+// which requires signed URLs. Image URLs stay valid for expiry
+func (c *HTTPClient) WithSigner(s *signing.Signer, expiry time.Duration) *HTTPClient {
+	c.signer = s
+	c.expiry = expiry
+	return c
+}
+
+// sign returns the query authorising a GET of path until expires, empty without a signer
+func (c *HTTPClient) sign(path string, expires time.Time) string {
+	if c.signer == nil {
+		return ""
+	}
+	return "?" + c.signer.Sign(http.MethodGet, path, expires, 0).Encode()
+}
+
+// fileInfo is the part of the file-server's listing we use
+type fileInfo struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Images lists the product's directory in the file-server
+func (c *HTTPClient) Images(ctx context.Context, productID int) ([]Image, error) {
+	id := strconv.Itoa(productID)
+	now := time.Now()
+
+	// the listing only has to be valid for this call
+	list := "/images/" + id
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+list+c.sign(list, now.Add(time.Minute)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("file-server returned status %d", resp.StatusCode)
+	}
+
+	var files []fileInfo
+	err = json.NewDecoder(resp.Body).Decode(&files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode images: %w", err)
+	}
+
+	images := make([]Image, 0, len(files))
+	for _, f := range files {
+		// the file-server verifies the unescaped path
+		q := c.sign("/images/"+id+"/"+f.Filename, now.Add(c.expiry))
+		images = append(images, Image{
+			Filename:    f.Filename,
+			URL:         c.publicURL + "/images/" + id + "/" + url.PathEscape(f.Filename) + q,
+			Size:        f.Size,
+			ContentType: f.ContentType,
+			ModTime:     f.ModTime,
+		})
+	}
+
+	return images, nil
+}
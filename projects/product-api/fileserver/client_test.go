@@ -0,0 +1,79 @@
+package fileserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"shared/signing"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"id":"1","filename":"front view.png","size":42,"content_type":"image/png"}]`))
+	}))
+	defer srv.Close()
+
+	c := NewHTTPClient(srv.URL, "http://cdn.example.com/", time.Second)
+
+	images, err := c.Images(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(images))
+	}
+	if images[0].URL != "http://cdn.example.com/images/1/front%20view.png" || images[0].Size != 42 {
+		t.Fatalf("unexpected image %+v", images[0])
+	}
+
+	if _, err := c.Images(context.Background(), 2); err == nil {
+		t.Fatal("expected an error for a failed listing")
+	}
+}
+
+func TestHTTPClientSigned(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"))
+
+	// the file-server refuses unsigned listings when it requires signatures
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.Verify(r.Method, r.URL.Path, r.URL.Query(), time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`[{"id":"1","filename":"front view.png","size":42}]`))
+	}))
+	defer srv.Close()
+
+	if _, err := NewHTTPClient(srv.URL, "", time.Second).Images(context.Background(), 1); err == nil {
+		t.Fatal("expected an unsigned listing to fail")
+	}
+
+	c := NewHTTPClient(srv.URL, "", time.Second).WithSigner(s, time.Hour)
+	images, err := c.Images(context.Background(), 1)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("expected 1 image, got %v %v", images, err)
+	}
+
+	// the image URL is signed for the path the file-server sees
+	u, err := url.Parse(images[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/images/1/front view.png" {
+		t.Fatalf("unexpected image URL %s", images[0].URL)
+	}
+	if _, err := s.Verify(http.MethodGet, u.Path, u.Query(), time.Now().Add(50*time.Minute)); err != nil {
+		t.Errorf("expected a URL valid for an hour, got %v", err)
+	}
+	if _, err := s.Verify(http.MethodGet, u.Path, u.Query(), time.Now().Add(2*time.Hour)); err == nil {
+		t.Error("expected the URL to expire")
+	}
+}
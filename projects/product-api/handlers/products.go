@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"product-api/data"
+	"product-api/fileserver"
+	"shared/accesslog"
 	"strconv"
 	"strings"
 
@@ -15,12 +17,27 @@ import (
 
 // ProductsHandler handles product-related HTTP requests
 type ProductsHandler struct {
-	l *log.Logger
+	l *slog.Logger
+
+	// images looks up product images in the file-server
+	images fileserver.Client
+
+	// find loads a product, data.FindProduct unless a test replaces it
+	find func(ctx context.Context, id int) (*data.Product, error)
 }
 
 // NewProductsHandler This is like a constructor in java, it initializes the struct
-func NewProductsHandler(l *log.Logger) *ProductsHandler {
-	return &ProductsHandler{l: l}
+func NewProductsHandler(l *slog.Logger, images fileserver.Client) *ProductsHandler {
+	return &ProductsHandler{l: l, images: images, find: data.FindProduct}
+}
+
+// logger returns the handler's logger with the id of the request in ctx, so the
+// lines of a request can be found next to its access log line
+func (p *ProductsHandler) logger(ctx context.Context) *slog.Logger {
+	if id := accesslog.RequestID(ctx); id != "" {
+		return p.l.With("request_id", id)
+	}
+	return p.l
 }
 
 // swagger:route GET / products listProducts
@@ -33,8 +50,9 @@ func NewProductsHandler(l *log.Logger) *ProductsHandler {
 func (p *ProductsHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 
 	// get all products from the data package
-	Products, err := data.GetProducts()
+	Products, err := data.GetProducts(r.Context())
 	if err != nil {
+		p.logger(r.Context()).Error("Unable to retrieve products", "error", err)
 		http.Error(w, "Unable to retrieve products", http.StatusInternalServerError)
 		return
 	}
@@ -49,6 +67,85 @@ func (p *ProductsHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// swagger:route GET /product/{id} products getProduct
+// Gets a single product
+// responses:
+//	200: productResponse
+//  400: errorResponse
+//  404: errorResponse
+//  500: errorResponse
+
+// GetProduct returns the product with the id from the URL
+func (p *ProductsHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := p.findProduct(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(product)
+	if err != nil {
+		http.Error(w, "Unable to marshal json", http.StatusInternalServerError)
+	}
+}
+
+// swagger:route GET /product/{id}/images products listProductImages
+// Gets the images of a product from the file-server
+// responses:
+//	200: imagesResponse
+//  400: errorResponse
+//  404: errorResponse
+//  502: errorResponse
+
+// GetProductImages returns the URLs of the product's images in the file-server
+func (p *ProductsHandler) GetProductImages(w http.ResponseWriter, r *http.Request) {
+	product, ok := p.findProduct(w, r)
+	if !ok {
+		return
+	}
+
+	l := p.logger(r.Context()).With("product_id", product.ID, "sku", product.SKU)
+	l.Debug("Handle GET Product images")
+
+	images, err := p.images.Images(r.Context(), product.ID)
+	if err != nil {
+		l.Error("Unable to list images from file-server", "error", err)
+		http.Error(w, "Unable to retrieve images", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(images)
+	if err != nil {
+		http.Error(w, "Unable to marshal json", http.StatusInternalServerError)
+	}
+}
+
+// findProduct loads the product with the id from the URL, writing the error
+// response and returning false when it can't
+func (p *ProductsHandler) findProduct(w http.ResponseWriter, r *http.Request) (*data.Product, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Unable to convert id to int", http.StatusBadRequest)
+		return nil, false
+	}
+
+	product, err := p.find(r.Context(), id)
+	if err == data.ErrProductNotFound {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		p.logger(r.Context()).Error("Unable to retrieve product", "product_id", id, "error", err)
+		http.Error(w, "Unable to retrieve product", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return product, true
+}
+
 // swagger:route POST /product products createProduct
 // Creates a new product
 // responses:
@@ -60,22 +157,26 @@ func (p *ProductsHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 // AddProduct adds a new product to the database
 // passing ProductsHandler as receiver so that we can call this method on ProductsHandler type
 func (p *ProductsHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
-	p.l.Println("Handle POST Products")
-
 	// Get the product from the context, which was set by the MiddlewareProductValidation
 	product := r.Context().Value(KeyProduct{}).(*data.Product)
 
+	l := p.logger(r.Context()).With("sku", product.SKU)
+	l.Info("Handle POST Products")
+
 	// add the product to the data store
-	err := data.AddProduct(product)
+	err := data.AddProduct(r.Context(), product)
 	if err != nil {
 		// Check for duplicate SKU error
 		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			l.Info("Product SKU already exists")
 			http.Error(w, fmt.Sprintf("Product with SKU '%s' already exists", product.SKU), http.StatusConflict)
 			return
 		}
+		l.Error("Unable to add product", "error", err)
 		http.Error(w, fmt.Sprintf("Unable to add product: %v", err), http.StatusInternalServerError)
 		return
 	}
+	l.Info("Product created", "product_id", product.ID)
 
 	// Set proper Content-Type header and status for JSON response
 	w.Header().Set("Content-Type", "application/json")
@@ -100,8 +201,6 @@ func (p *ProductsHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
 
 // UpdateProducts updates an existing product
 func (p *ProductsHandler) UpdateProducts(w http.ResponseWriter, r *http.Request) {
-	p.l.Println("Handle PUT Products")
-
 	// Get the product ID from the URL parameters using gorilla/mux
 	vars := mux.Vars(r)
 
@@ -115,8 +214,11 @@ func (p *ProductsHandler) UpdateProducts(w http.ResponseWriter, r *http.Request)
 	// Get the product from the context, which was set by the MiddlewareProductValidation
 	product := r.Context().Value(KeyProduct{}).(*data.Product)
 
+	l := p.logger(r.Context()).With("product_id", id, "sku", product.SKU)
+	l.Info("Handle PUT Products")
+
 	// Call the UpdateProduct method from the data package to update the product
-	err = data.UpdateProduct(id, product)
+	err = data.UpdateProduct(r.Context(), id, product)
 
 	// Check if the product was not found or if there was another error
 	if err == data.ErrProductNotFound {
@@ -127,9 +229,11 @@ func (p *ProductsHandler) UpdateProducts(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		// Check for duplicate SKU error
 		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			l.Info("Product SKU already exists")
 			http.Error(w, fmt.Sprintf("Product with SKU '%s' already exists", product.SKU), http.StatusConflict)
 			return
 		}
+		l.Error("Unable to update product", "error", err)
 		http.Error(w, fmt.Sprintf("Unable to update product: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -151,7 +255,8 @@ type KeyProduct struct{}
 // MiddlewareProductValidation to validate the product data before processing the request and passing it to the next handler
 func (p *ProductsHandler) MiddlewareProductValidation(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		p.l.Println("Product validation middleware")
+		l := p.logger(r.Context())
+		l.Debug("Product validation middleware")
 
 		//like doing this in java, Product p = new Product()
 		product := &data.Product{}
@@ -160,6 +265,7 @@ func (p *ProductsHandler) MiddlewareProductValidation(next http.Handler) http.Ha
 		err := product.FromJSON(r.Body)
 
 		if err != nil {
+			l.Debug("Unable to unmarshal product", "error", err)
 			http.Error(w, "Unable to unmarshal json", http.StatusBadRequest)
 			return
 		}
@@ -167,6 +273,7 @@ func (p *ProductsHandler) MiddlewareProductValidation(next http.Handler) http.Ha
 		// Validate the product struct using the ValidateProduct method that we defined in the data package
 		err = product.ValidateProduct()
 		if err != nil {
+			l.Debug("Invalid product", "sku", product.SKU, "error", err)
 			http.Error(w, fmt.Sprintf("Error Validating product: %s", err), http.StatusBadRequest)
 			return
 		}
@@ -191,7 +298,7 @@ type productParamsWrapper struct {
 	Body data.Product
 }
 
-// swagger:parameters updateProduct
+// swagger:parameters updateProduct getProduct listProductImages
 type productIDParamsWrapper struct {
 	// Product ID
 	// in: path
@@ -215,6 +322,14 @@ type productResponseWrapper struct {
 	Body data.Product
 }
 
+// The images of a product
+// swagger:response imagesResponse
+type imagesResponseWrapper struct {
+	// Product images
+	// in: body
+	Body []fileserver.Image
+}
+
 // Error response
 // swagger:response errorResponse
 type errorResponseWrapper struct {
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"product-api/data"
+	"product-api/fileserver"
+	"shared/accesslog"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeImages is a file-server client returning fixed images or an error
+type fakeImages struct {
+	images []fileserver.Image
+	err    error
+}
+
+func (f *fakeImages) Images(ctx context.Context, productID int) ([]fileserver.Image, error) {
+	return f.images, f.err
+}
+
+// newTestHandler creates a handler whose only product is id 1
+func newTestHandler(images fileserver.Client) *ProductsHandler {
+	ph := NewProductsHandler(discard, images)
+	ph.find = func(ctx context.Context, id int) (*data.Product, error) {
+		if id != 1 {
+			return nil, data.ErrProductNotFound
+		}
+		return &data.Product{ID: 1, Name: "Tea", Price: 1, SKU: "abc-def-ghi"}, nil
+	}
+	return ph
+}
+
+// serve routes a GET request to the handler's product routes
+func serve(ph *ProductsHandler, target string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/product/{id:[0-9]+}", ph.GetProduct)
+	r.HandleFunc("/product/{id:[0-9]+}/images", ph.GetProductImages)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, target, nil))
+	return rw
+}
+
+func TestGetProduct(t *testing.T) {
+	ph := newTestHandler(&fakeImages{})
+
+	rw := serve(ph, "/product/1")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rw.Code)
+	}
+	p := &data.Product{}
+	if err := json.NewDecoder(rw.Body).Decode(p); err != nil || p.SKU != "abc-def-ghi" {
+		t.Errorf("unexpected product %+v %v", p, err)
+	}
+
+	if rw := serve(ph, "/product/2"); rw.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rw.Code)
+	}
+
+	ph.find = func(context.Context, int) (*data.Product, error) { return nil, errors.New("connection refused") }
+	if rw := serve(ph, "/product/1"); rw.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rw.Code)
+	}
+}
+
+func TestGetProductImages(t *testing.T) {
+	images := []fileserver.Image{{Filename: "a.png", URL: "http://files/images/1/a.png", Size: 3}}
+
+	tests := []struct {
+		name   string
+		target string
+		client *fakeImages
+		status int
+	}{
+		{"success", "/product/1/images", &fakeImages{images: images}, http.StatusOK},
+		{"product not found", "/product/2/images", &fakeImages{images: images}, http.StatusNotFound},
+		{"file-server down", "/product/1/images", &fakeImages{err: errors.New("connection refused")}, http.StatusBadGateway},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := serve(newTestHandler(tc.client), tc.target)
+			if rw.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, rw.Code)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var got []fileserver.Image
+			if err := json.NewDecoder(rw.Body).Decode(&got); err != nil || len(got) != 1 || got[0].URL != images[0].URL {
+				t.Errorf("unexpected images %+v %v", got, err)
+			}
+		})
+	}
+}
+
+func TestValidationLogsRequest(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ph := NewProductsHandler(l, nil)
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Error("expected the invalid product to be rejected")
+	})
+	h := accesslog.New(l, nil).Middleware(ph.MiddlewareProductValidation(next))
+
+	r := httptest.NewRequest(http.MethodPost, "/product", strings.NewReader(`{"name":"Tea","price":1,"sku":"tea"}`))
+	r.Header.Set(accesslog.Header, "req-1")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rw.Code)
+	}
+	for _, w := range []string{`msg="Invalid product"`, "request_id=req-1", "sku=tea"} {
+		if !strings.Contains(buf.String(), w) {
+			t.Errorf("expected %q in the log, got:\n%s", w, buf.String())
+		}
+	}
+}
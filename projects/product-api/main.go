@@ -2,61 +2,111 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
 	"product-api/config"
 	"product-api/data"
 	"product-api/database"
+	"product-api/fileserver"
 	"product-api/handlers"
+	"shared/accesslog"
+	"shared/conf"
+	"shared/cors"
+	"shared/health"
+	"shared/lifecycle"
+	"shared/metrics"
+	"shared/signing"
+	"shared/trace"
 	"time"
 
-	gohandlers "github.com/gorilla/handlers"
-
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	// Create a logger that writes to stdout with a prefix and timestamp
-	l := log.New(os.Stdout, "product-api ", log.LstdFlags)
-
 	// Load configuration
-	cfg := config.LoadConfig()
-
-	// Convert config to database config format
-	dbConfig := database.Config{
-		Host:     cfg.DatabaseConfig.Host,
-		Port:     cfg.DatabaseConfig.Port,
-		User:     cfg.DatabaseConfig.User,
-		Password: cfg.DatabaseConfig.Password,
-		DBName:   cfg.DatabaseConfig.DBName,
-		SSLMode:  cfg.DatabaseConfig.SSLMode,
+	cfg, err := config.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
 	}
 
+	// Create a structured logger writing logfmt or JSON to stdout, its level can be
+	// changed by reloading the config. It is also the default logger, used by the
+	// data package for requests without a logger of their own
+	level := new(slog.LevelVar)
+	level.Set(cfg.LogConfig.SlogLevel())
+	l, _ := accesslog.NewLogger(os.Stdout, cfg.LogConfig.Format, level)
+	l = l.With("service", "product-api")
+	slog.SetDefault(l)
+
 	// Initialize database connection
-	db, err := database.NewConnection(dbConfig)
+	db, err := database.NewConnection(cfg.DatabaseConfig, l)
 	if err != nil {
-		l.Fatal("Failed to connect to database: ", err)
+		l.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
-	defer db.Close()
 
 	// Create tables
 	if err := db.CreateTables(); err != nil {
-		l.Fatal("Failed to create tables: ", err)
+		l.Error("Failed to create tables", "error", err)
+		os.Exit(1)
 	}
 
 	// Initialize the product repository
 	data.InitializeRepository(db.DB)
 
+	// product images live in the file-server
+	// with the file-server's signing key the listings and image URLs are signed,
+	// so it can require signed URLs
+	fs := fileserver.NewHTTPClient(cfg.FileServerConfig.URL, cfg.FileServerConfig.PublicURL, cfg.FileServerConfig.Timeout)
+	if cfg.FileServerConfig.SigningKey != "" {
+		fs.WithSigner(signing.NewSigner([]byte(cfg.FileServerConfig.SigningKey)), cfg.FileServerConfig.URLExpiry)
+	}
+
+	// spans of requests, SQL queries and file-server calls, continuing the trace
+	// of the caller's traceparent header
+	tr, err := trace.New(cfg.TracingConfig, "product-api", func(err error) {
+		l.Warn("Unable to export spans", "error", err)
+	})
+	if err != nil {
+		l.Error("Failed to create tracer", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize handler instances with the logger
-	ph := handlers.NewProductsHandler(l)
+	ph := handlers.NewProductsHandler(l, fs)
 
 	// using gorilla/mux for routing, its a powerful HTTP router and URL matcher for building Go web servers
 	sm := mux.NewRouter()
 
+	// trace every request, first so the other middleware runs inside its span
+	sm.Use(tr.Middleware)
+
+	// give every request an id, returned in X-Request-ID, passed on to the file
+	// server and added to the request's log lines, and write an access line per
+	// request to stdout. Requests matching no route are logged by the NotFoundHandler
+	var access *slog.Logger
+	if cfg.AccessLogConfig.Enabled {
+		access, _ = accesslog.NewLogger(os.Stdout, cfg.AccessLogConfig.Format, slog.LevelInfo)
+	}
+	al := accesslog.New(l, access)
+	sm.Use(al.Middleware)
+	sm.NotFoundHandler = al.Middleware(http.NotFoundHandler())
+
+	// per route request counts and latencies plus the database pool stats,
+	// scraped by Prometheus from /metrics
+	mr := metrics.NewRegistry()
+	metrics.DBStats(mr, db.DB)
+	sm.Use(metrics.NewHTTP(mr).Middleware)
+
 	// using gorilla/mux, we can create subrouters for different HTTP methods
 	getRouter := sm.Methods(http.MethodGet).Subrouter()
 
@@ -64,6 +114,16 @@ func main() {
 	// because handleFunc expects a function with the signature(w http.ResponseWriter, r *http.Request)
 	// and GetProducts matches that signature
 	getRouter.HandleFunc("/", ph.GetProducts)
+	getRouter.HandleFunc("/product/{id:[0-9]+}", ph.GetProduct)
+	getRouter.HandleFunc("/product/{id:[0-9]+}/images", ph.GetProductImages)
+
+	// liveness and readiness probes, readiness pings Postgres and fails once
+	// a graceful shutdown starts
+	hr := health.NewRegistry("product-api", 2*time.Second)
+	hr.Register("database", db.PingContext)
+	getRouter.HandleFunc("/livez", hr.Live)
+	getRouter.HandleFunc("/readyz", hr.Ready)
+	getRouter.Handle("/metrics", mr)
 
 	putRouter := sm.Methods(http.MethodPut).Subrouter()
 	putRouter.HandleFunc("/product/{id:[0-9]+}", ph.UpdateProducts)
@@ -79,56 +139,45 @@ func main() {
 	sm.Handle("/docs", sh)
 	sm.Handle("/swagger.yaml", http.FileServer(http.Dir("./")))
 
-	//CORS middleware to allow cross-origin requests from browsers
-	ch := gohandlers.CORS(
-		// allow all origins, methods, and headers
-		gohandlers.AllowedOrigins([]string{"*"}),
-		gohandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+	//CORS middleware to allow cross-origin requests from browsers, the origins,
+	// credentials, extra exposed headers and max age come from the config
+	cp := cors.NewPolicy(cfg.CORSConfig, cors.Options{
+		Methods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		//allowed 2 headers for requests
-		gohandlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-	)
+		Headers: []string{"Content-Type", "Authorization"},
+	})
 
 	//sm.Handle("/", hh) // Maps "/" to Hello handler
 
 	// Define the custom HTTP server configuration
+	// a request listing images waits up to the file-server timeout, leave room
+	// after it to write the images or the error
+	writeTimeout := cfg.FileServerConfig.Timeout + time.Second
 	serverAddr := fmt.Sprintf(":%d", cfg.ServerConfig.Port)
 	s := &http.Server{
 		Addr:         serverAddr,        // Server will listen on configured port
-		Handler:      ch(sm),            // Use our custom router, wrapped CORS middleware
+		Handler:      cp.Handler(sm),    // Use our custom router, wrapped CORS middleware
 		IdleTimeout:  120 * time.Second, // Max idle time before disconnect
 		ReadTimeout:  1 * time.Second,   // Max time to read a request
-		WriteTimeout: 1 * time.Second,   // Max time to write a response
+		WriteTimeout: writeTimeout,      // Max time to write a response
 	}
 
-	// Start the HTTP server in a new goroutine
-	// Goroutine is a lightweight thread in Go — it allows the server to run in the background
-	// so the main thread can wait for OS signals (like Ctrl+C)
-	go func() {
-		err := s.ListenAndServe()
-		if err != nil {
-			l.Fatal("Error starting server: ", err)
-		}
-	}()
-
-	// Create a channel to receive OS signals (like Interrupt or Kill)
-	sigChain := make(chan os.Signal)
-
-	// signal.Notify will send OS interrupt/kill signals into sigChain
-	// This is how we detect Ctrl+C or program termination
-	signal.Notify(sigChain, os.Interrupt)
-	signal.Notify(sigChain, os.Kill)
-
-	// Wait here until we receive a shutdown signal
-	// This blocks the main thread until a signal is received
-	sig := <-sigChain
-	l.Println("Received terminate, Graceful shutdown", sig)
-
-	// Create a context with timeout of 30 seconds to allow graceful shutdown
-	// This gives running requests a chance to complete before server exits
-	tc, _ := context.WithTimeout(context.Background(), 30*time.Second)
-
-	// Shutdown the server gracefully using the context timeout
-	s.Shutdown(tc)
-
-	log.Println("Server stopped gracefully")
+	// apply config changes on SIGHUP or when the config file changes
+	rl := &reloader{args: os.Args[1:], cur: cfg, l: l, cors: cp, level: level}
+
+	// Serve until SIGINT or SIGTERM, then fail readiness so no new traffic is sent,
+	// give running requests up to 30 seconds to complete, stop the config watcher,
+	// send the spans still queued and close the database pool, in that order.
+	// The exit code is 1 when the server fails or shutting down doesn't complete
+	code := lifecycle.New(s, l).
+		WithTimeout(30*time.Second).
+		OnDrain(hr.Shutdown).
+		Go("config watcher", func(ctx context.Context) error {
+			conf.Watch(ctx, cfg.File(), configPollInterval, rl.reload)
+			return nil
+		}).
+		OnClose("tracer", tr.Shutdown).
+		OnClose("database", func(context.Context) error { return db.Close() }).
+		Run(context.Background())
+	os.Exit(code)
 }
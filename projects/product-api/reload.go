@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"product-api/config"
+	"shared/conf"
+	"shared/cors"
+	"time"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloader applies the settings tagged reload:"true" in config.AppConfig to the
+// running API when the config is reloaded, other changes are logged as needing
+// a restart
+type reloader struct {
+	args []string
+	cur  *config.AppConfig
+	l    *slog.Logger
+	cors *cors.Policy
+
+	// level of l, set from log.level
+	level *slog.LevelVar
+}
+
+// reload loads the config again, an invalid config is rejected and the current
+// one kept. It is called by conf.Watch, never concurrently
+func (r *reloader) reload(reason string) {
+	cfg, err := config.LoadConfig(r.args)
+	if err != nil {
+		r.l.Error("Rejected config reload, keeping the current config", "reason", reason, "error", err)
+		return
+	}
+
+	changes := conf.Diff(r.cur, cfg)
+	if len(changes) == 0 {
+		r.l.Info("Config reloaded, nothing changed", "reason", reason)
+		return
+	}
+
+	// the new reloadable settings must also be valid next to the restart-only
+	// settings still running
+	next := *r.cur
+	conf.CopyReloadable(&next, cfg)
+	if err := next.Validate(); err != nil {
+		r.l.Error("Rejected config reload, keeping the current config", "reason", reason, "error", err)
+		return
+	}
+
+	for _, c := range changes {
+		if !c.Reload {
+			r.l.Warn("Config change needs a restart, ignoring it", "change", c.String())
+			continue
+		}
+		r.l.Info("Config changed", "change", c.String())
+	}
+
+	r.cors.Set(next.CORSConfig)
+	r.level.Set(next.LogConfig.SlogLevel())
+	r.cur = &next
+
+	r.l.Info("Config reloaded", "reason", reason)
+}
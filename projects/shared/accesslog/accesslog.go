@@ -0,0 +1,175 @@
+// Package accesslog gives each request an id, taken from its X-Request-ID header
+// or generated, and writes a structured line per request served
+package accesslog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"shared/internal/httpx"
+	"shared/trace"
+)
+
+// Header carries the request id, it is set on the response and on calls made
+// with Transport
+const Header = "X-Request-ID"
+
+// Formats are the values of Config.Format
+var Formats = []string{"logfmt", "json"}
+
+// Config chooses how access lines are written, a service keeps it under the
+// access_log key of its config
+type Config struct {
+	Enabled bool   `yaml:"enabled" env:"ENABLED" default:"true" usage:"write a line per request served"`
+	Format  string `yaml:"format" env:"FORMAT" default:"logfmt" usage:"format of the access log, logfmt or json"`
+}
+
+// Validate checks the settings, keys are given under access_log
+func (c Config) Validate() error {
+	if !slices.Contains(Formats, c.Format) {
+		return fmt.Errorf("access_log.format: must be one of %v, got %q", Formats, c.Format)
+	}
+	return nil
+}
+
+// NewLogger creates a logger writing format, logfmt or json, to w
+func NewLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, errors.New("unknown log format " + format)
+}
+
+// AccessLog assigns request ids and writes the access log
+type AccessLog struct {
+	app    *slog.Logger // given to handlers, tagged with the request id
+	access *slog.Logger // nil when the access log is disabled
+}
+
+// New creates the middleware. app is the service's logger put in each request's
+// context, with the request id added; access writes the access lines and may be
+// nil to only assign ids
+func New(app, access *slog.Logger) *AccessLog {
+	return &AccessLog{app: app, access: access}
+}
+
+// Middleware serves next with the request's id in its context and response,
+// then logs the request. Add it to a gorilla router with Use to log the route
+// template, and wrap the router's NotFoundHandler to log unmatched requests
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(Header)
+		if !validID(id) {
+			id = newID()
+		}
+		rw.Header().Set(Header, id)
+
+		ctx := context.WithValue(r.Context(), idKey{}, id)
+		if a.app != nil {
+			ctx = context.WithValue(ctx, loggerKey{}, a.app.With("request_id", id))
+		}
+		trace.SpanFrom(ctx).SetAttributes(trace.String("http.request.id", id))
+
+		sw := httpx.NewResponseWriter(rw)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(sw, r)
+
+		if a.access == nil {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", httpx.Route(r)),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.Status()),
+			slog.Int64("bytes", sw.Written()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if sc := trace.SpanContextFrom(ctx); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()))
+		}
+		a.access.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// Transport sets the X-Request-ID header of requests sent with Base to the id
+// of the request in their context, so a call can be found in the logs of the
+// service it was made to
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if id := RequestID(r.Context()); id != "" && r.Header.Get(Header) == "" {
+		// a RoundTripper must not change the request it was given
+		r = r.Clone(r.Context())
+		r.Header.Set(Header, id)
+	}
+	return base.RoundTrip(r)
+}
+
+type idKey struct{}
+
+type loggerKey struct{}
+
+// RequestID returns the id of the request in ctx, empty outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Logger returns the logger of the request in ctx, tagged with its id, or
+// slog.Default outside a request
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// validID reports whether a request id from a client can be used as it is,
+// ids are limited so they can't be used to forge or flood log lines
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
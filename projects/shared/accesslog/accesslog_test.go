@@ -0,0 +1,130 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddleware(t *testing.T) {
+	var app, access bytes.Buffer
+	l, _ := NewLogger(&access, "json", slog.LevelInfo)
+	al := New(slog.New(slog.NewJSONHandler(&app, nil)), l)
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/product/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		Logger(r.Context()).Info("found product", "id", mux.Vars(r)["id"])
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("hello"))
+	})
+	sm.Use(al.Middleware)
+	sm.NotFoundHandler = al.Middleware(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodPost, "/product/42", nil)
+	req.Header.Set(Header, "client-id.1")
+	rw := httptest.NewRecorder()
+	sm.ServeHTTP(rw, req)
+
+	if got := rw.Header().Get(Header); got != "client-id.1" {
+		t.Fatalf("expected the client's request id to be kept, got %q", got)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(access.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON access line, got %q", access.String())
+	}
+	want := map[string]any{
+		"msg": "request", "request_id": "client-id.1", "method": "POST", "route": "/product/{id}",
+		"path": "/product/42", "status": float64(201), "bytes": float64(5),
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, line[k])
+		}
+	}
+	if _, ok := line["duration_ms"]; !ok {
+		t.Errorf("expected a duration in %v", line)
+	}
+
+	if !strings.Contains(app.String(), `"request_id":"client-id.1"`) || !strings.Contains(app.String(), `"id":"42"`) {
+		t.Errorf("expected the handler's log line to carry the request id, got %q", app.String())
+	}
+
+	// unmatched requests are logged through the NotFoundHandler with a new id
+	access.Reset()
+	rw = httptest.NewRecorder()
+	sm.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	id := rw.Header().Get(Header)
+	if len(id) != 32 || !strings.Contains(access.String(), `"status":404`) || !strings.Contains(access.String(), id) {
+		t.Errorf("expected a logged 404 with a generated id, got %q %q", id, access.String())
+	}
+}
+
+func TestInvalidIDReplaced(t *testing.T) {
+	for _, id := range []string{"has space", "new\nline", strings.Repeat("a", 129), `quote"`} {
+		h := New(nil, nil).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(Header, id)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if got := rw.Header().Get(Header); got == id || len(got) != 32 {
+			t.Errorf("expected %q to be replaced, got %q", id, got)
+		}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	var access bytes.Buffer
+	l, err := NewLogger(&access, "logfmt", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := New(nil, l).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	for _, w := range []string{"msg=request", "method=GET", "path=/livez", "status=200", "bytes=0"} {
+		if !strings.Contains(access.String(), w) {
+			t.Errorf("expected %q in %q", w, access.String())
+		}
+	}
+
+	if _, err := NewLogger(&access, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(Header)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+	h := New(nil, nil).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(Header, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "abc" {
+		t.Fatalf("expected the request id to be passed on, got %q", got)
+	}
+}
@@ -0,0 +1,244 @@
+// Package conf fills a configuration struct from, lowest precedence first, default
+// tags, a YAML file, environment variables and command line flags.
+//
+// Every exported field with an env tag is a setting:
+//
+//	Port     int           `yaml:"port" env:"PORT" default:"9080" usage:"port to listen on"`
+//	Password string        `yaml:"password" env:"PASSWORD" required:"true" secret:"true"`
+//	Timeout  time.Duration `yaml:"timeout" env:"TIMEOUT" default:"5s"`
+//	Origins  []string      `yaml:"origins" env:"ORIGINS" default:"*"`
+//
+// Fields of a nested struct prefix their yaml keys with the struct's yaml key and a
+// dot and their env names with the struct's env name and an underscore. The flag
+// for a setting is its env name, without the prefix, in lower case with - for _.
+//
+// A setting can also be read from the file named by its environment variable with
+// _FILE appended, e.g. DB_PASSWORD_FILE, so secrets can be mounted as files
+// rather than passed in the environment.
+//
+// Settings tagged reload:"true" can be changed while the service runs, see Watch
+// and Diff, secret:"true" keeps their values out of diffs
+package conf
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options controls where Load reads settings from
+type Options struct {
+	// Name of the program shown in the flag usage
+	Name string
+	// EnvPrefix starts the name of every environment variable, e.g. FILESERVER_
+	EnvPrefix string
+	// FileEnv names the environment variable holding the config file path, the
+	// -config flag always can. Empty means only the flag is used
+	FileEnv string
+	// Args are the command line arguments without the program name
+	Args []string
+	// Usage receives the flag usage for -h and flag errors, os.Stderr if nil
+	Usage io.Writer
+}
+
+// Setting describes one leaf value of a config struct
+type Setting struct {
+	Key      string // dotted path in the config file, used in errors
+	Env      string // environment variable, including the prefix
+	Flag     string // command line flag without the leading -
+	Default  string
+	Usage    string
+	Required bool
+	Secret   bool
+	Reload   bool // can be applied without a restart
+
+	value reflect.Value
+}
+
+// Source describes where Load read the configuration from
+type Source struct {
+	// Args are the command line arguments left after the flags
+	Args []string
+	// File is the config file read, empty when there was none
+	File string
+}
+
+// Value returns the current value of the setting
+func (s Setting) Value() any {
+	return s.value.Interface()
+}
+
+// Settings returns the settings of the struct pointed to by cfg
+func Settings(cfg any, envPrefix string) []Setting {
+	return walk(reflect.ValueOf(cfg).Elem(), "", envPrefix, envPrefix)
+}
+
+func walk(v reflect.Value, keyPrefix, envPrefix, strip string) []Setting {
+	var out []Setting
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		env := sf.Tag.Get("env")
+		if !sf.IsExported() || key == "-" || env == "" {
+			continue
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && !isText(fv) {
+			out = append(out, walk(fv, keyPrefix+key+".", envPrefix+env+"_", strip)...)
+			continue
+		}
+
+		name := envPrefix + env
+		out = append(out, Setting{
+			Key:      keyPrefix + key,
+			Env:      name,
+			Flag:     strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, strip), "_", "-")),
+			Default:  sf.Tag.Get("default"),
+			Usage:    sf.Tag.Get("usage"),
+			Required: sf.Tag.Get("required") == "true",
+			Secret:   sf.Tag.Get("secret") == "true",
+			Reload:   sf.Tag.Get("reload") == "true",
+			value:    fv,
+		})
+	}
+
+	return out
+}
+
+// Load fills the struct pointed to by cfg, see the package documentation, and
+// returns where it was read from. It returns flag.ErrHelp for -h
+func Load(cfg any, o Options) (*Source, error) {
+	if o.Usage == nil {
+		o.Usage = os.Stderr
+	}
+	ss := Settings(cfg, o.EnvPrefix)
+
+	for _, s := range ss {
+		if s.Default == "" {
+			continue
+		}
+		err := setValue(s.value, s.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default for %s: %w", s.Key, err)
+		}
+	}
+
+	// flags are read first so -config can name the file, they are applied last
+	fs := flag.NewFlagSet(o.Name, flag.ContinueOnError)
+	fs.SetOutput(o.Usage)
+	help := "YAML config file"
+	if o.FileEnv != "" {
+		help += " (env " + o.FileEnv + ")"
+	}
+	path := fs.String("config", os.Getenv(o.FileEnv), help)
+
+	flags := map[string]string{}
+	for _, s := range ss {
+		name := s.Flag
+		help := s.Usage + " (env " + s.Env + ")"
+		if s.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, help, func(v string) error { flags[name] = v; return nil })
+			continue
+		}
+		fs.Func(name, help, func(v string) error { flags[name] = v; return nil })
+	}
+
+	err := fs.Parse(o.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		err = loadFile(*path, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range ss {
+		v, ok, err := lookupEnv(s.Env)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		err = setValue(s.value, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Env, err)
+		}
+	}
+
+	for _, s := range ss {
+		v, ok := flags[s.Flag]
+		if !ok {
+			continue
+		}
+		err = setValue(s.value, v)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", s.Flag, err)
+		}
+	}
+
+	var errs []error
+	for _, s := range ss {
+		if s.Required && s.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s: must be set (env %s or -%s)", s.Key, s.Env, s.Flag))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &Source{Args: fs.Args(), File: *path}, nil
+}
+
+// lookupEnv returns the value of the environment variable name, or the contents
+// of the file named by name_FILE with the trailing newline removed
+func lookupEnv(name string) (string, bool, error) {
+	v, ok := os.LookupEnv(name)
+
+	p, fromFile := os.LookupEnv(name + "_FILE")
+	if !fromFile {
+		return v, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("%s: only one of %s and %s_FILE can be set", name, name, name)
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+// loadFile decodes the YAML file at path over cfg, unknown keys are an error
+// so a misspelt setting isn't silently ignored
+func loadFile(path string, cfg any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open config file: %w", err)
+	}
+	defer f.Close()
+
+	d := yaml.NewDecoder(f)
+	d.KnownFields(true)
+
+	err = d.Decode(cfg)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package conf
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" env:"NAME" default:"app" usage:"name" reload:"true"`
+	Port    int           `yaml:"port" env:"PORT" default:"8080"`
+	Debug   bool          `yaml:"debug" env:"DEBUG"`
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" default:"5s"`
+	Tags    []string      `yaml:"tags" env:"TAGS" default:"a,b"`
+	Size    ByteSize      `yaml:"size" env:"SIZE" default:"1KB"`
+
+	DB struct {
+		Host     string `yaml:"host" env:"HOST" default:"localhost"`
+		Password string `yaml:"password" env:"PASSWORD" required:"true" secret:"true"`
+	} `yaml:"db" env:"DB"`
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	p := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(p, []byte(contents), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func load(t *testing.T, args ...string) (*testConfig, []string, error) {
+	cfg := &testConfig{}
+	src, err := Load(cfg, Options{Name: "test", EnvPrefix: "TEST_", FileEnv: "TEST_CONFIG", Args: args, Usage: io.Discard})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, src.Args, nil
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "secret")
+
+	cfg, _, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Name != "app" || cfg.Port != 8080 || cfg.Timeout != 5*time.Second || cfg.Size != 1024 || cfg.DB.Host != "localhost" {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if strings.Join(cfg.Tags, ",") != "a,b" {
+		t.Errorf("unexpected default list %v", cfg.Tags)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv("TEST_CONFIG", writeFile(t, "config.yaml", "name: file\nport: 1\ntags: [x, y]\nsize: 2MB\ndb:\n  host: db.file\n  password: file\n"))
+	t.Setenv("TEST_PORT", "2")
+	t.Setenv("TEST_DB_HOST", "db.env")
+
+	cfg, rest, err := load(t, "-db-host", "db.flag", "-debug", "-tags", "z", "cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Name != "file" || cfg.Size != 2<<20 || cfg.DB.Password != "file" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.Port != 2 {
+		t.Errorf("env should override the file, got %d", cfg.Port)
+	}
+	if cfg.DB.Host != "db.flag" || !cfg.Debug || strings.Join(cfg.Tags, ",") != "z" {
+		t.Errorf("flags should override env, got %+v", cfg)
+	}
+	if len(rest) != 1 || rest[0] != "cmd" {
+		t.Errorf("expected the cmd argument, got %v", rest)
+	}
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD_FILE", writeFile(t, "password", "s3cret\n"))
+
+	cfg, _, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Password != "s3cret" {
+		t.Errorf("expected the password from the file, got %q", cfg.DB.Password)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{
+			name: "required setting missing",
+			want: []string{"db.password", "TEST_DB_PASSWORD", "-db-password"},
+		},
+		{
+			name: "value and file both set",
+			env:  map[string]string{"TEST_DB_PASSWORD": "a", "TEST_DB_PASSWORD_FILE": "/dev/null"},
+			want: []string{"only one of TEST_DB_PASSWORD and TEST_DB_PASSWORD_FILE"},
+		},
+		{
+			name: "missing secret file",
+			env:  map[string]string{"TEST_DB_PASSWORD_FILE": "/does/not/exist"},
+			want: []string{"TEST_DB_PASSWORD_FILE"},
+		},
+		{
+			name: "bad env value",
+			env:  map[string]string{"TEST_DB_PASSWORD": "a", "TEST_TIMEOUT": "soon"},
+			want: []string{"TEST_TIMEOUT", "invalid duration"},
+		},
+		{
+			name: "bad flag value",
+			env:  map[string]string{"TEST_DB_PASSWORD": "a"},
+			args: []string{"-port", "http"},
+			want: []string{"-port", "invalid number"},
+		},
+		{
+			name: "missing config file",
+			env:  map[string]string{"TEST_DB_PASSWORD": "a"},
+			args: []string{"-config", "does-not-exist.yaml"},
+			want: []string{"unable to open config file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, _, err := load(t, tt.args...)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("expected %q in error %q", w, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "a")
+
+	_, _, err := load(t, "-config", writeFile(t, "config.yaml", "prot: 1\n"))
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Fatalf("expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	_, _, err := load(t, "-h")
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("expected flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	ss := Settings(&testConfig{}, "TEST_")
+
+	var pw *Setting
+	for i := range ss {
+		if ss[i].Key == "db.password" {
+			pw = &ss[i]
+		}
+	}
+	if pw == nil || pw.Env != "TEST_DB_PASSWORD" || pw.Flag != "db-password" || !pw.Required || !pw.Secret {
+		t.Fatalf("unexpected setting %+v", pw)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := map[string]ByteSize{"512": 512, "64KB": 64 << 10, "5mb": 5 << 20, "1 GB": 1 << 30}
+
+	for in, want := range tests {
+		var b ByteSize
+		if err := b.UnmarshalText([]byte(in)); err != nil || b != want {
+			t.Errorf("%s: expected %d, got %d %v", in, want, b, err)
+		}
+	}
+
+	var b ByteSize
+	if err := b.UnmarshalText([]byte("lots")); err == nil {
+		t.Error("expected an error for lots")
+	}
+}
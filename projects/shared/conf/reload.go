@@ -0,0 +1,116 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// redacted replaces the value of secret settings in a Change
+const redacted = "<redacted>"
+
+// Change is a setting whose value differs between two configurations
+type Change struct {
+	Key    string
+	Old    string
+	New    string
+	Reload bool // false when the new value only takes effect after a restart
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// Diff returns the settings which differ between old and new, both pointers to
+// the same config struct type. Secret values are redacted
+func Diff(old, new any) []Change {
+	from, to := Settings(old, ""), Settings(new, "")
+
+	var out []Change
+	for i, s := range from {
+		ov, nv := s.Value(), to[i].Value()
+		if reflect.DeepEqual(ov, nv) {
+			continue
+		}
+
+		c := Change{Key: s.Key, Old: fmt.Sprint(ov), New: fmt.Sprint(nv), Reload: s.Reload}
+		if s.Secret {
+			c.Old, c.New = redacted, redacted
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
+
+// Watch calls reload when the process receives SIGHUP or, when file is not
+// empty, when the file's size or modification time changes. The file is checked
+// every interval as not every platform or mount can notify us of changes.
+// reload is never called concurrently and Watch returns when ctx is done
+func Watch(ctx context.Context, file string, interval time.Duration, reload func(reason string)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if file != "" {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	last := stat(file)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last = stat(file)
+			reload("SIGHUP")
+		case <-tick:
+			cur := stat(file)
+			if cur == last {
+				continue
+			}
+			last = cur
+			reload("config file changed")
+		}
+	}
+}
+
+// fileState is what Watch compares to spot a changed file
+type fileState struct {
+	size    int64
+	modTime time.Time
+	exists  bool
+}
+
+func stat(file string) fileState {
+	if file == "" {
+		return fileState{}
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{size: fi.Size(), modTime: fi.ModTime(), exists: true}
+}
+
+// CopyReloadable sets the settings tagged reload:"true" in dst to their values
+// in src, both pointers to the same config struct type. Applying a new config
+// this way leaves the settings which need a restart as they were
+func CopyReloadable(dst, src any) {
+	to, from := Settings(dst, ""), Settings(src, "")
+
+	for i, s := range to {
+		if s.Reload {
+			s.value.Set(from[i].value)
+		}
+	}
+}
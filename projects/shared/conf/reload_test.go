@@ -0,0 +1,96 @@
+package conf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old, new := &testConfig{}, &testConfig{}
+	old.Name, new.Name = "a", "b"
+	old.Port, new.Port = 1, 1
+	old.DB.Password, new.DB.Password = "one", "two"
+	new.Tags = []string{"x"}
+
+	got := Diff(old, new)
+
+	want := []Change{
+		{Key: "name", Old: "a", New: "b", Reload: true},
+		{Key: "tags", Old: "[]", New: "[x]"},
+		{Key: "db.password", Old: redacted, New: redacted},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+}
+
+func TestCopyReloadable(t *testing.T) {
+	cur, next := &testConfig{Name: "a", Port: 1}, &testConfig{Name: "b", Port: 2}
+
+	CopyReloadable(cur, next)
+
+	if cur.Name != "b" {
+		t.Errorf("expected the reloadable name to be copied, got %q", cur.Name)
+	}
+	if cur.Port != 1 {
+		t.Errorf("expected the port to need a restart, got %d", cur.Port)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte("port: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reasons := make(chan string, 4)
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, p, 10*time.Millisecond, func(reason string) { reasons <- reason })
+		close(done)
+	}()
+
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case r := <-reasons:
+			if r != want {
+				t.Fatalf("expected reload for %q, got %q", want, r)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no reload for %q", want)
+		}
+	}
+
+	// give Watch time to record the file and subscribe to SIGHUP
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.WriteFile(p, []byte("port: 22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wait("config file changed")
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	wait("SIGHUP")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
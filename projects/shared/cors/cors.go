@@ -0,0 +1,205 @@
+// Package cors applies a configurable Cross-Origin Resource Sharing policy using
+// gorilla/handlers. The policy can be replaced while the server runs, e.g. when
+// the service reloads its config
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gohandlers "github.com/gorilla/handlers"
+)
+
+// Config is the part of the CORS policy which changes between environments, a
+// service keeps it under the cors key of its config
+type Config struct {
+	// origins may be exact, https://shop.example.com, a wildcard subdomain,
+	// https://*.example.com, or * for any origin
+	AllowedOrigins []string `yaml:"allowed_origins" env:"ALLOWED_ORIGINS" reload:"true" default:"*" usage:"comma separated origins allowed by CORS, https://*.example.com matches subdomains, * any origin"`
+	// let browsers send cookies and Authorization headers, needs listed origins
+	AllowCredentials bool `yaml:"allow_credentials" env:"ALLOW_CREDENTIALS" reload:"true" usage:"allow credentialed requests, can't be used with *"`
+	// response headers browsers may read, added to the ones the service exposes
+	ExposedHeaders []string `yaml:"exposed_headers" env:"EXPOSED_HEADERS" reload:"true" usage:"comma separated response headers browsers may read, added to the service's own"`
+	// how long browsers may cache a preflight response, zero leaves it to the browser
+	MaxAge time.Duration `yaml:"max_age" env:"MAX_AGE" reload:"true" default:"10m" usage:"how long browsers may cache a preflight response"`
+}
+
+// Validate checks the policy and returns all the problems found, keys are
+// given under cors
+func (c Config) Validate() error {
+	var errs []error
+
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowed_origins: must list at least one origin or *"))
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			if c.AllowCredentials {
+				errs = append(errs, errors.New("cors.allowed_origins: * can't be used with cors.allow_credentials, list the origins"))
+			}
+			continue
+		}
+		if _, err := parseOrigin(o); err != nil {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins: %w", err))
+		}
+	}
+	if c.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age: must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options is the part of the policy fixed by the service's API
+type Options struct {
+	Methods        []string // methods allowed in cross-origin requests
+	Headers        []string // request headers allowed in cross-origin requests
+	ExposedHeaders []string // response headers browsers may read
+}
+
+// Policy is a CORS policy which can be replaced at any time
+type Policy struct {
+	opts Options
+	cur  atomic.Pointer[policy]
+}
+
+// policy is one Config compiled for serving
+type policy struct {
+	any     bool
+	origins []origin
+	gorilla []gohandlers.CORSOption
+}
+
+// NewPolicy creates the policy, cfg must be valid
+func NewPolicy(cfg Config, opts Options) *Policy {
+	p := &Policy{opts: opts}
+	p.Set(cfg)
+	return p
+}
+
+// Set replaces the configurable part of the policy, cfg must be valid
+func (p *Policy) Set(cfg Config) {
+	c := &policy{}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			c.any = true
+			continue
+		}
+		if po, err := parseOrigin(o); err == nil {
+			c.origins = append(c.origins, po)
+		}
+	}
+
+	c.gorilla = []gohandlers.CORSOption{
+		gohandlers.AllowedMethods(p.opts.Methods),
+		gohandlers.AllowedHeaders(p.opts.Headers),
+		gohandlers.ExposedHeaders(append(append([]string{}, p.opts.ExposedHeaders...), cfg.ExposedHeaders...)),
+		gohandlers.MaxAge(int(cfg.MaxAge / time.Second)),
+	}
+	if c.any {
+		// gorilla answers * rather than echoing the origin
+		c.gorilla = append(c.gorilla, gohandlers.AllowedOrigins([]string{"*"}))
+	} else {
+		c.gorilla = append(c.gorilla, gohandlers.AllowedOriginValidator(c.allowed))
+	}
+	if cfg.AllowCredentials {
+		c.gorilla = append(c.gorilla, gohandlers.AllowCredentials())
+	}
+
+	p.cur.Store(c)
+}
+
+// Allowed reports whether origin may make cross-origin requests
+func (p *Policy) Allowed(origin string) bool {
+	c := p.cur.Load()
+	return c.any || c.allowed(origin)
+}
+
+// Handler wraps next with the policy
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return &handler{p: p, next: next}
+}
+
+// handler rebuilds the gorilla handler the first time it serves a request after
+// the policy changed
+type handler struct {
+	p     *Policy
+	next  http.Handler
+	built atomic.Pointer[built]
+}
+
+type built struct {
+	policy *policy
+	h      http.Handler
+}
+
+func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c := h.p.cur.Load()
+
+	b := h.built.Load()
+	if b == nil || b.policy != c {
+		b = &built{policy: c, h: gohandlers.CORS(c.gorilla...)(h.next)}
+		h.built.Store(b)
+	}
+
+	// the response depends on the origin unless every origin gets the same one
+	if !c.any {
+		rw.Header().Add("Vary", "Origin")
+	}
+
+	b.h.ServeHTTP(rw, r)
+}
+
+func (c *policy) allowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, o := range c.origins {
+		if o.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// origin is an allowed origin, host may start with *. to match any subdomain
+type origin struct {
+	scheme string
+	host   string
+}
+
+// parseOrigin parses an allowed origin like https://shop.example.com or
+// https://*.example.com
+func parseOrigin(s string) (origin, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return origin{}, fmt.Errorf("%q is not * or an origin like https://example.com or https://*.example.com", s)
+	}
+
+	host := strings.ToLower(u.Host)
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return origin{}, fmt.Errorf("%q can only use * for the leftmost subdomain, e.g. https://*.example.com", s)
+	}
+
+	return origin{scheme: strings.ToLower(u.Scheme), host: host}, nil
+}
+
+func (o origin) match(u *url.URL) bool {
+	if !strings.EqualFold(u.Scheme, o.scheme) {
+		return false
+	}
+
+	host := strings.ToLower(u.Host)
+	if suffix, ok := strings.CutPrefix(o.host, "*"); ok {
+		// *.example.com matches a.example.com and a.b.example.com but not example.com
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == o.host
+}
@@ -0,0 +1,146 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	Methods:        []string{"GET", "POST", "DELETE"},
+	Headers:        []string{"Content-Type", "Authorization"},
+	ExposedHeaders: []string{"Link"},
+}
+
+func TestPreflight(t *testing.T) {
+	listed := Config{
+		AllowedOrigins:   []string{"https://shop.example.com", "https://*.admin.example.com"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Next-Cursor"},
+		MaxAge:           10 * time.Minute,
+	}
+	anyOrigin := Config{AllowedOrigins: []string{"*"}}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		origin  string
+		method  string
+		headers string
+
+		status      int
+		allowOrigin string
+		credentials string
+		maxAge      string
+		vary        bool
+	}{
+		{"exact origin", listed, "https://shop.example.com", "POST", "Authorization", 200, "https://shop.example.com", "true", "600", true},
+		{"wildcard subdomain", listed, "https://eu.admin.example.com", "DELETE", "", 200, "https://eu.admin.example.com", "true", "600", true},
+		{"nested wildcard subdomain", listed, "https://a.eu.admin.example.com", "GET", "", 200, "https://a.eu.admin.example.com", "true", "600", true},
+		{"wildcard does not match the bare domain", listed, "https://admin.example.com", "GET", "", 200, "", "", "", true},
+		{"other origin", listed, "https://evil.example.com", "POST", "", 200, "", "", "", true},
+		{"suffix attack", listed, "https://shop.example.com.evil.com", "POST", "", 200, "", "", "", true},
+		{"wrong scheme", listed, "http://shop.example.com", "POST", "", 200, "", "", "", true},
+		{"wrong port", listed, "https://shop.example.com:8443", "POST", "", 200, "", "", "", true},
+		{"method not allowed", listed, "https://shop.example.com", "PATCH", "", 405, "", "", "", true},
+		{"header not allowed", listed, "https://shop.example.com", "POST", "X-Secret", 403, "", "", "", true},
+		{"any origin", anyOrigin, "https://anything.example.org", "POST", "Content-Type", 200, "*", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			h := NewPolicy(tt.cfg, testOptions).Handler(http.NotFoundHandler())
+
+			r := httptest.NewRequest(http.MethodOptions, "/images/1", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			if rw.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rw.Code)
+			}
+			for _, c := range []struct{ header, want string }{
+				{"Access-Control-Allow-Origin", tt.allowOrigin},
+				{"Access-Control-Allow-Credentials", tt.credentials},
+				{"Access-Control-Max-Age", tt.maxAge},
+			} {
+				if got := rw.Header().Get(c.header); got != c.want {
+					t.Errorf("expected %s %q, got %q", c.header, c.want, got)
+				}
+			}
+			if got := rw.Header().Get("Vary") == "Origin"; got != tt.vary {
+				t.Errorf("expected Vary: Origin %v, got %q", tt.vary, rw.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestExposedHeaders(t *testing.T) {
+	h := NewPolicy(Config{AllowedOrigins: []string{"https://shop.example.com"}, ExposedHeaders: []string{"X-Next-Cursor"}}, testOptions).
+		Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/images/1", nil)
+	r.Header.Set("Origin", "https://shop.example.com")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+
+	if got := rw.Header().Get("Access-Control-Expose-Headers"); got != "Link,X-Next-Cursor" {
+		t.Errorf("expected the service and configured headers to be exposed, got %q", got)
+	}
+}
+
+func TestPolicySet(t *testing.T) {
+	p := NewPolicy(Config{AllowedOrigins: []string{"https://a.example.com"}}, testOptions)
+	h := p.Handler(http.NotFoundHandler())
+
+	allowOrigin := func(origin string) string {
+		r := httptest.NewRequest(http.MethodOptions, "/", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if allowOrigin("https://b.example.com") != "" {
+		t.Fatal("expected b to be refused")
+	}
+
+	p.Set(Config{AllowedOrigins: []string{"https://b.example.com"}, MaxAge: time.Minute})
+
+	if allowOrigin("https://a.example.com") != "" || allowOrigin("https://b.example.com") != "https://b.example.com" {
+		t.Error("expected the new origins to apply to the existing handler")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg Config
+		ok  bool
+	}{
+		"listed origins":       {Config{AllowedOrigins: []string{"https://a.example.com", "http://localhost:3000"}, AllowCredentials: true}, true},
+		"wildcard subdomain":   {Config{AllowedOrigins: []string{"https://*.example.com"}}, true},
+		"any origin":           {Config{AllowedOrigins: []string{"*"}}, true},
+		"no origins":           {Config{}, false},
+		"any with credentials": {Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		"inner wildcard":       {Config{AllowedOrigins: []string{"https://a.*.example.com"}}, false},
+		"path":                 {Config{AllowedOrigins: []string{"https://a.example.com/app"}}, false},
+		"no scheme":            {Config{AllowedOrigins: []string{"a.example.com"}}, false},
+		"negative max age":     {Config{AllowedOrigins: []string{"*"}, MaxAge: -time.Second}, false},
+	}
+
+	for name, tt := range tests {
+		err := tt.cfg.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", name, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package health serves liveness and readiness endpoints. Liveness says the
+// process is running, readiness runs the registered dependency checks and fails
+// once the service starts shutting down so load balancers stop sending traffic
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statuses reported for the service and for each check
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+)
+
+// CheckFunc checks one dependency, it should give up when ctx is done
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body of the /livez and /readyz responses
+type Report struct {
+	Status  string   `json:"status"`
+	Service string   `json:"service"`
+	Checks  []Result `json:"checks,omitempty"`
+}
+
+// Registry holds the readiness checks of a service
+type Registry struct {
+	service string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	names  []string
+	checks map[string]CheckFunc
+
+	shuttingDown atomic.Bool
+}
+
+// NewRegistry creates an empty registry, each check is given at most timeout
+func NewRegistry(service string, timeout time.Duration) *Registry {
+	return &Registry{service: service, timeout: timeout, checks: map[string]CheckFunc{}}
+}
+
+// Register adds a readiness check, registering a name again replaces its check
+func (r *Registry) Register(name string, c CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = c
+}
+
+// Shutdown makes readiness fail from now on, call it before draining the server
+func (r *Registry) Shutdown() {
+	r.shuttingDown.Store(true)
+}
+
+// Check runs every check concurrently and returns the report, ok is false when
+// a check failed or the service is shutting down
+func (r *Registry) Check(ctx context.Context) (Report, bool) {
+	r.mu.Lock()
+	names := append([]string{}, r.names...)
+	checks := make([]CheckFunc, len(names))
+	for i, n := range names {
+		checks[i] = r.checks[n]
+	}
+	r.mu.Unlock()
+
+	rep := Report{Status: StatusOK, Service: r.service, Checks: make([]Result, len(names))}
+
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.Checks[i] = r.run(ctx, names[i], checks[i])
+		}()
+	}
+	wg.Wait()
+
+	ok := true
+	for _, res := range rep.Checks {
+		if res.Status != StatusOK {
+			ok = false
+			rep.Status = StatusFailing
+		}
+	}
+	if r.shuttingDown.Load() {
+		ok = false
+		rep.Status = StatusShuttingDown
+	}
+
+	return rep, ok
+}
+
+// run runs one check with the registry's timeout
+func (r *Registry) run(ctx context.Context, name string, c CheckFunc) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c(ctx)
+	res := Result{Name: name, Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status = StatusFailing
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Live handles /livez, it answers 200 while the process can serve requests
+func (r *Registry) Live(rw http.ResponseWriter, req *http.Request) {
+	write(rw, http.StatusOK, Report{Status: StatusOK, Service: r.service})
+}
+
+// Ready handles /readyz, it answers 200 when every check passes and 503
+// when one fails or the service is shutting down
+func (r *Registry) Ready(rw http.ResponseWriter, req *http.Request) {
+	rep, ok := r.Check(req.Context())
+
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	write(rw, code, rep)
+}
+
+func write(rw http.ResponseWriter, code int, rep Report) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(rep)
+}
+
+// DirWritable returns a check which creates and removes a hidden file in dir
+func DirWritable(dir string) CheckFunc {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString("ok")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+
+		return err
+	}
+}
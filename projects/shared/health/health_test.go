@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, h http.HandlerFunc) (int, Report) {
+	t.Helper()
+
+	rw := httptest.NewRecorder()
+	h(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var rep Report
+	if err := json.NewDecoder(rw.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	return rw.Code, rep
+}
+
+func TestReady(t *testing.T) {
+	r := NewRegistry("test", 50*time.Millisecond)
+	r.Register("database", func(ctx context.Context) error { return nil })
+
+	code, rep := get(t, r.Ready)
+	if code != http.StatusOK || rep.Status != StatusOK || len(rep.Checks) != 1 || rep.Checks[0].Status != StatusOK {
+		t.Fatalf("expected ready, got %d %+v", code, rep)
+	}
+
+	r.Register("storage", func(ctx context.Context) error { return errors.New("disk full") })
+	r.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, rep = get(t, r.Ready)
+	if code != http.StatusServiceUnavailable || rep.Status != StatusFailing {
+		t.Fatalf("expected failing, got %d %+v", code, rep)
+	}
+
+	want := []Result{
+		{Name: "database", Status: StatusOK},
+		{Name: "storage", Status: StatusFailing, Error: "disk full"},
+		{Name: "slow", Status: StatusFailing, Error: context.DeadlineExceeded.Error()},
+	}
+	for i, w := range want {
+		got := rep.Checks[i]
+		if got.Name != w.Name || got.Status != w.Status || got.Error != w.Error {
+			t.Errorf("expected %+v, got %+v", w, got)
+		}
+	}
+	if rep.Checks[2].LatencyMS < 50 {
+		t.Errorf("expected the slow check to take the timeout, got %vms", rep.Checks[2].LatencyMS)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	r := NewRegistry("test", time.Second)
+	r.Register("database", func(ctx context.Context) error { return nil })
+
+	r.Shutdown()
+
+	code, rep := get(t, r.Ready)
+	if code != http.StatusServiceUnavailable || rep.Status != StatusShuttingDown {
+		t.Fatalf("expected readiness to fail during shutdown, got %d %+v", code, rep)
+	}
+
+	code, rep = get(t, r.Live)
+	if code != http.StatusOK || rep.Status != StatusOK {
+		t.Fatalf("expected liveness to pass during shutdown, got %d %+v", code, rep)
+	}
+}
+
+func TestDirWritable(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := DirWritable(dir)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expected the check to clean up, found %d files", len(entries))
+	}
+
+	if err := DirWritable(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Error("expected a missing directory to fail")
+	}
+}
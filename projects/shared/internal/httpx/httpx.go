@@ -0,0 +1,93 @@
+// Package httpx holds the HTTP helpers shared by the middleware packages
+package httpx
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// templateRegexp matches the pattern part of a variable in a gorilla route
+// template, {id:[0-9]+} becomes {id}
+var templateRegexp = regexp.MustCompile(`\{([^{}:]+):[^{}]*(?:\{[^{}]*\}[^{}]*)*\}`)
+
+// Route returns the template of the gorilla route or the path of the
+// http.ServeMux pattern the request matched, empty when there is none. Gorilla
+// only knows the route in middleware added with Use, a ServeMux only once it has
+// served the request
+func Route(r *http.Request) string {
+	cr := mux.CurrentRoute(r)
+	if cr == nil {
+		return patternPath(r.Pattern)
+	}
+
+	t, err := cr.GetPathTemplate()
+	if err != nil {
+		return patternPath(r.Pattern)
+	}
+	return templateRegexp.ReplaceAllString(t, "{$1}")
+}
+
+// patternPath drops the method from a ServeMux pattern, "GET /hello/{name}"
+// becomes "/hello/{name}"
+func patternPath(p string) string {
+	if _, path, ok := strings.Cut(p, " "); ok {
+		return strings.TrimLeft(path, " \t")
+	}
+	return p
+}
+
+// ResponseWriter records the status code and size of a response
+type ResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// NewResponseWriter wraps rw
+func NewResponseWriter(rw http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: rw}
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses, e.g. server-sent events, through the middleware
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code sent, 200 when the handler wrote nothing
+func (w *ResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Written returns the number of body bytes written
+func (w *ResponseWriter) Written() int64 {
+	return w.written
+}
@@ -0,0 +1,241 @@
+// Package lifecycle runs an HTTP server until the process is asked to stop, then
+// shuts the service down in order: readiness is failed, in-flight requests are
+// drained, background workers are stopped and waited for, and resources such
+// as the database pool are closed
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK      = 0 // stopped by a signal or its context and shut down cleanly
+	ExitFailure = 1 // the server or a worker failed, or shutting down did not complete
+)
+
+// DefaultTimeout is how long shutting down may take unless WithTimeout is used
+const DefaultTimeout = 30 * time.Second
+
+// Logger is satisfied by *slog.Logger and hclog.Logger
+type Logger interface {
+	Info(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WorkerFunc is a background worker, it must return once ctx is done. An error
+// returned before shutdown stops the service
+type WorkerFunc func(ctx context.Context) error
+
+// CloseFunc releases a resource during shutdown, it should give up when ctx is done
+type CloseFunc func(ctx context.Context) error
+
+type worker struct {
+	name string
+	run  WorkerFunc
+}
+
+type closer struct {
+	name  string
+	close CloseFunc
+}
+
+// Runner runs a server and the service's background workers, created with New
+type Runner struct {
+	server  *http.Server
+	ln      net.Listener
+	log     Logger
+	timeout time.Duration
+	signals []os.Signal
+
+	draining []func()
+	workers  []worker
+	closers  []closer
+}
+
+// New creates a Runner for s logging to l, slog.Default when l is nil. It stops
+// on SIGINT or SIGTERM
+func New(s *http.Server, l Logger) *Runner {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &Runner{
+		server:  s,
+		log:     l,
+		timeout: DefaultTimeout,
+		signals: []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+}
+
+// WithTimeout sets how long shutting down may take, after it running requests
+// are abandoned and the remaining steps are cut short
+func (r *Runner) WithTimeout(d time.Duration) *Runner {
+	r.timeout = d
+	return r
+}
+
+// WithSignals sets the signals which stop the service
+func (r *Runner) WithSignals(sig ...os.Signal) *Runner {
+	r.signals = sig
+	return r
+}
+
+// WithListener serves on ln rather than listening on the server's Addr
+func (r *Runner) WithListener(ln net.Listener) *Runner {
+	r.ln = ln
+	return r
+}
+
+// OnDrain calls fn when shutdown starts, before requests are drained, e.g. to
+// fail readiness so load balancers stop sending traffic
+func (r *Runner) OnDrain(fn func()) *Runner {
+	r.draining = append(r.draining, fn)
+	return r
+}
+
+// Go runs fn in the background while the service runs. Its context is
+// cancelled once requests are drained and shutdown waits for it to return
+func (r *Runner) Go(name string, fn WorkerFunc) *Runner {
+	r.workers = append(r.workers, worker{name: name, run: fn})
+	return r
+}
+
+// OnClose calls fn once requests are drained and the workers have stopped.
+// Close functions are called in the order they were added
+func (r *Runner) OnClose(name string, fn CloseFunc) *Runner {
+	r.closers = append(r.closers, closer{name: name, close: fn})
+	return r
+}
+
+// Run serves until ctx is done, a signal is received or the server or a worker
+// fails, then shuts down and returns the exit code. A second signal while
+// shutting down gives up waiting
+func (r *Runner) Run(ctx context.Context) int {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, r.signals...)
+	defer signal.Stop(sigs)
+
+	// server and worker failures, buffered so nothing blocks once Run stops reading
+	failed := make(chan error, len(r.workers)+1)
+
+	r.log.Info("Starting server", "address", r.addr())
+	go func() {
+		var err error
+		if r.ln != nil {
+			err = r.server.Serve(r.ln)
+		} else {
+			err = r.server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	wctx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var wg sync.WaitGroup
+	for _, w := range r.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := w.run(wctx)
+			if err != nil && wctx.Err() == nil {
+				r.log.Error("Worker failed", "worker", w.name, "error", err)
+				failed <- err
+			}
+		}()
+	}
+
+	code := ExitOK
+	select {
+	case sig := <-sigs:
+		r.log.Info("Shutting down server", "signal", sig.String())
+	case <-ctx.Done():
+		r.log.Info("Shutting down server", "reason", context.Cause(ctx).Error())
+	case err := <-failed:
+		r.log.Error("Server failed, shutting down", "error", err)
+		code = ExitFailure
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-sigs:
+			r.log.Error("Received a second signal, giving up waiting", "signal", sig.String())
+			cancel()
+		case <-sctx.Done():
+		}
+	}()
+
+	for _, fn := range r.draining {
+		fn()
+	}
+
+	err := r.server.Shutdown(sctx)
+	if err != nil {
+		r.log.Error("Unable to drain requests", "error", err)
+		r.server.Close()
+		code = ExitFailure
+	}
+
+	stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-sctx.Done():
+		r.log.Error("Workers did not stop in time")
+		code = ExitFailure
+	}
+
+	for _, c := range r.closers {
+		err := c.close(sctx)
+		if err != nil {
+			r.log.Error("Unable to close", "name", c.name, "error", err)
+			code = ExitFailure
+		}
+	}
+
+	if code == ExitOK {
+		r.log.Info("Server stopped gracefully")
+	}
+	return code
+}
+
+// Every returns a worker calling fn every d until its context is done
+func Every(d time.Duration, fn func(ctx context.Context)) WorkerFunc {
+	return func(ctx context.Context) error {
+		t := time.NewTicker(d)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-t.C:
+				fn(ctx)
+			}
+		}
+	}
+}
+
+func (r *Runner) addr() string {
+	if r.ln != nil {
+		return r.ln.Addr().String()
+	}
+	return r.server.Addr
+}
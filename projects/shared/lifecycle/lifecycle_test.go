@@ -0,0 +1,230 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// steps records the order shutdown steps happen in
+type steps struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (s *steps) add(step string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, step)
+}
+
+func (s *steps) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.list)
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+// run starts r in the background, returning its exit code on the channel
+func run(ctx context.Context, r *Runner) <-chan int {
+	code := make(chan int, 1)
+	go func() { code <- r.Run(ctx) }()
+	return code
+}
+
+func waitCode(t *testing.T, code <-chan int) int {
+	t.Helper()
+	select {
+	case c := <-code:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+		return -1
+	}
+}
+
+func TestRunDrainsInOrder(t *testing.T) {
+	var st steps
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		st.add("request")
+	})}
+	ln := listen(t)
+
+	r := New(s, discard).
+		WithListener(ln).
+		OnDrain(func() { st.add("drain") }).
+		Go("worker", func(ctx context.Context) error {
+			<-ctx.Done()
+			st.add("worker")
+			return nil
+		}).
+		OnClose("tracer", func(ctx context.Context) error { st.add("tracer"); return nil }).
+		OnClose("db", func(ctx context.Context) error { st.add("db"); return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	code := run(ctx, r)
+
+	resp := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resp <- 0
+			return
+		}
+		res.Body.Close()
+		resp <- res.StatusCode
+	}()
+	<-started
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-code:
+		t.Fatal("expected Run to wait for the running request")
+	default:
+	}
+	close(release)
+
+	if c := waitCode(t, code); c != ExitOK {
+		t.Errorf("expected exit code %d, got %d", ExitOK, c)
+	}
+	if s := <-resp; s != http.StatusOK {
+		t.Errorf("expected the running request to complete, got status %d", s)
+	}
+
+	want := []string{"drain", "request", "worker", "tracer", "db"}
+	if got := st.get(); !slices.Equal(got, want) {
+		t.Errorf("expected steps %v, got %v", want, got)
+	}
+}
+
+func TestRunSignal(t *testing.T) {
+	ln := listen(t)
+	s := &http.Server{Handler: http.NotFoundHandler()}
+	code := run(context.Background(), New(s, discard).WithListener(ln).WithSignals(syscall.SIGUSR1))
+
+	// the signal is only caught once Run is serving
+	for i := 0; ; i++ {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p, _ := os.FindProcess(os.Getpid())
+	p.Signal(syscall.SIGUSR1)
+
+	if c := waitCode(t, code); c != ExitOK {
+		t.Errorf("expected exit code %d, got %d", ExitOK, c)
+	}
+}
+
+func TestRunServerFails(t *testing.T) {
+	ln := listen(t)
+	ln.Close()
+
+	var closed atomic.Bool
+	r := New(&http.Server{}, discard).
+		WithListener(ln).
+		OnClose("db", func(ctx context.Context) error { closed.Store(true); return nil })
+
+	if c := waitCode(t, run(context.Background(), r)); c != ExitFailure {
+		t.Errorf("expected exit code %d, got %d", ExitFailure, c)
+	}
+	if !closed.Load() {
+		t.Error("expected resources to be closed after a failure")
+	}
+}
+
+func TestRunWorkerFails(t *testing.T) {
+	r := New(&http.Server{}, discard).
+		WithListener(listen(t)).
+		Go("webhooks", func(ctx context.Context) error { return errors.New("outbox unreadable") })
+
+	if c := waitCode(t, run(context.Background(), r)); c != ExitFailure {
+		t.Errorf("expected exit code %d, got %d", ExitFailure, c)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	ln := listen(t)
+	started := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+
+	var closeErr error
+	r := New(s, discard).
+		WithListener(ln).
+		WithTimeout(50*time.Millisecond).
+		OnClose("db", func(ctx context.Context) error { closeErr = ctx.Err(); return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	code := run(ctx, r)
+
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+
+	if c := waitCode(t, code); c != ExitFailure {
+		t.Errorf("expected exit code %d, got %d", ExitFailure, c)
+	}
+	if closeErr == nil {
+		t.Error("expected close functions to get an expired context")
+	}
+}
+
+func TestEvery(t *testing.T) {
+	var n atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- Every(5*time.Millisecond, func(context.Context) { n.Add(1) })(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n.Load() == 0 {
+		t.Error("expected fn to be called")
+	}
+}
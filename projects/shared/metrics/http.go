@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shared/internal/httpx"
+)
+
+// Unmatched is the route label of requests which matched no route
+const Unmatched = "unmatched"
+
+// HTTP records the requests served by a router
+type HTTP struct {
+	requests *CounterVec
+	duration *HistogramVec
+	inFlight *Gauge
+}
+
+// NewHTTP registers the request metrics in r
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		requests: r.Counter("http_requests_total", "Requests served by route, method and status code.", "route", "method", "code"),
+		duration: r.Histogram("http_request_duration_seconds", "Time taken to serve requests by route and method.", DefBuckets, "route", "method"),
+		inFlight: r.Gauge("http_requests_in_flight", "Requests being served.").With(),
+	}
+}
+
+// Middleware records the requests served by next, labelled with the route template,
+// e.g. /products/{id}, to keep the number of series bounded.
+//
+// Add it to a gorilla router with Use, which only runs it for requests matching a
+// route. Wrapping an http.ServeMux works too, the route is then the pattern the
+// mux matched
+func (m *HTTP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		sw := httpx.NewResponseWriter(rw)
+		next.ServeHTTP(sw, r)
+
+		route := httpx.Route(r)
+		if route == "" {
+			route = Unmatched
+		}
+
+		m.requests.With(route, r.Method, strconv.Itoa(sw.Status())).Inc()
+		m.duration.With(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Transfer returns middleware adding the request body bytes read to in and the
+// response body bytes written to out as they are transferred, either may be nil.
+// Error responses are not counted in out
+func Transfer(in, out *Counter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if in != nil && r.Body != nil && r.Body != http.NoBody {
+				r.Body = &countingReader{ReadCloser: r.Body, c: in}
+			}
+			if out != nil {
+				rw = &countingWriter{ResponseWriter: rw, c: out}
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// countingReader adds the bytes read to a counter
+type countingReader struct {
+	io.ReadCloser
+	c *Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.c.Add(float64(n))
+	return n, err
+}
+
+// countingWriter adds the bytes written to a counter unless the status is an error
+type countingWriter struct {
+	http.ResponseWriter
+	c      *Counter
+	failed bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.failed = code >= http.StatusBadRequest
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if !w.failed {
+		w.c.Add(float64(n))
+	}
+	return n, err
+}
+
+// Flush lets streamed responses through the middleware
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
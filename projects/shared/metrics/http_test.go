@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddlewareGorilla(t *testing.T) {
+	r := NewRegistry()
+	m := NewHTTP(r)
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/products/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}).Methods(http.MethodGet)
+	sm.HandleFunc("/products", func(rw http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost)
+	sm.Use(m.Middleware)
+
+	for _, p := range []string{"/products/1", "/products/2"} {
+		sm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	sm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/products", nil))
+
+	got := scrape(t, r)
+	for _, line := range []string{
+		`http_requests_total{route="/products/{id}",method="GET",code="404"} 2`,
+		`http_requests_total{route="/products",method="POST",code="200"} 1`,
+		`http_request_duration_seconds_count{route="/products/{id}",method="GET"} 2`,
+		"http_requests_in_flight 0",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, got)
+		}
+	}
+}
+
+func TestMiddlewareServeMux(t *testing.T) {
+	r := NewRegistry()
+	m := NewHTTP(r)
+
+	sm := http.NewServeMux()
+	sm.HandleFunc("GET /hello/{name}", func(rw http.ResponseWriter, r *http.Request) {})
+	h := m.Middleware(sm)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello/gopher", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	got := scrape(t, r)
+	for _, line := range []string{
+		`http_requests_total{route="/hello/{name}",method="GET",code="200"} 1`,
+		`http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, got)
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	r := NewRegistry()
+	in := r.Counter("in_bytes_total", "Bytes in.").With()
+	out := r.Counter("out_bytes_total", "Bytes out.").With()
+
+	h := Transfer(in, out)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		rw.Write(append(b, b...))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+
+	if in.Value() != 5 || out.Value() != 10 {
+		t.Fatalf("expected 5 bytes in and 10 out, got %v and %v", in.Value(), out.Value())
+	}
+
+	h = Transfer(in, out)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "not found", http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if out.Value() != 10 {
+		t.Fatalf("expected error responses not to be counted, got %v bytes out", out.Value())
+	}
+}
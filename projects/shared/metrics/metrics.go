@@ -0,0 +1,339 @@
+// Package metrics keeps counters, gauges and histograms and serves them in the
+// Prometheus text exposition format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are latency buckets in seconds suited to an HTTP API
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics of a service, it is an http.Handler serving /metrics
+type Registry struct {
+	mu      sync.Mutex
+	names   map[string]bool
+	metrics []metric
+}
+
+// metric is a family of series written together under one HELP and TYPE
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) add(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter, a value which only goes up, with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec: newVec[*Counter](name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.add(name, v)
+	return v
+}
+
+// Gauge registers a gauge, a value which can go up and down, with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec: newVec[*Gauge](name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.add(name, v)
+	return v
+}
+
+// Histogram registers a histogram counting observations into buckets, given as
+// increasing upper bounds, with the given label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{vec: newVec[*Histogram](name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.add(name, v)
+	return v
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(name, &funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// CounterFunc registers a counter whose value is read from fn on every scrape
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.add(name, &funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+// ServeHTTP writes every metric in the text exposition format
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	ms := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	w := bufio.NewWriter(rw)
+	for _, m := range ms {
+		m.write(w)
+	}
+	w.Flush()
+}
+
+// vec is a family of series of one metric, one per combination of label values
+type vec[T any] struct {
+	name, help, typ string
+	labels          []string
+	create          func() T
+
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	values []string
+	m      T
+}
+
+func newVec[T any](name, help, typ string, labels []string, create func() T) vec[T] {
+	return vec[T]{name: name, help: help, typ: typ, labels: labels, create: create, series: map[string]*series[T]{}}
+}
+
+// with returns the series for the label values, creating it on first use
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{values: append([]string{}, values...), m: v.create()}
+		v.series[key] = s
+	}
+	return s.m
+}
+
+// each calls fn for every series ordered by label values
+func (v *vec[T]) each(fn func(labels string, m T)) {
+	v.mu.Lock()
+	ss := make([]*series[T], 0, len(v.series))
+	for _, s := range v.series {
+		ss = append(ss, s)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(ss, func(i, j int) bool {
+		return strings.Join(ss[i].values, "\xff") < strings.Join(ss[j].values, "\xff")
+	})
+	for _, s := range ss {
+		fn(formatLabels(v.labels, s.values), s.m)
+	}
+}
+
+func (v *vec[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ)
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct{ vec[*Counter] }
+
+// With returns the counter for the label values, in the order the labels were registered
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatValue(c.Value()))
+	})
+}
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct{ vec[*Gauge] }
+
+// With returns the gauge for the label values, in the order the labels were registered
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatValue(g.Value()))
+	})
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct{ vec[*Histogram] }
+
+// With returns the histogram for the label values, in the order the labels were registered
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.each(func(labels string, h *Histogram) {
+		counts, count, sum := h.snapshot()
+
+		// the le label goes after the others
+		prefix := "{"
+		if labels != "" {
+			prefix = labels[:len(labels)-1] + ","
+		}
+
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += counts[i]
+			fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n", v.name, prefix, formatValue(b), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", v.name, prefix, count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatValue(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, count)
+	})
+}
+
+// Counter is a value which only goes up
+type Counter struct{ v atomicFloat }
+
+// Inc adds one
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add adds n, which must not be negative
+func (c *Counter) Add(n float64) {
+	if n < 0 {
+		panic("metrics: counters can not go down")
+	}
+	c.v.add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 { return c.v.load() }
+
+// Gauge is a value which can go up and down
+type Gauge struct{ v atomicFloat }
+
+// Set replaces the value
+func (g *Gauge) Set(n float64) { g.v.store(n) }
+
+// Add adds n, which may be negative
+func (g *Gauge) Add(n float64) { g.v.add(n) }
+
+// Inc adds one
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec subtracts one
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 { return g.v.load() }
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]uint64{}, h.counts...), h.count, h.sum
+}
+
+// funcMetric is a single series read from a function on every scrape
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", f.name, escapeHelp(f.help), f.name, f.typ, f.name, formatValue(f.fn()))
+}
+
+// atomicFloat is a float64 which can be updated from many goroutines
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (a *atomicFloat) load() float64 { return math.Float64frombits(a.bits.Load()) }
+
+func (a *atomicFloat) store(v float64) { a.bits.Store(math.Float64bits(v)) }
+
+func (a *atomicFloat) add(d float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rw.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("expected the text exposition format, got %q", ct)
+	}
+	return rw.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.Counter("jobs_total", "Jobs run.", "queue", "result")
+	c.With("mail", "ok").Add(2)
+	c.With("images", "failed").Inc()
+	c.With("mail", `say "hi"\`+"\n").Inc()
+
+	g := r.Gauge("workers", "Busy workers.")
+	g.With().Set(3)
+	g.With().Dec()
+
+	h := r.Histogram("job_seconds", "Time per job.", []float64{0.1, 1}, "queue")
+	h.With("mail").Observe(0.05)
+	h.With("mail").Observe(0.5)
+	h.With("mail").Observe(2)
+
+	r.GaugeFunc("temperature", "Line one\nline two.", func() float64 { return 21.5 })
+
+	want := `# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{queue="images",result="failed"} 1
+jobs_total{queue="mail",result="ok"} 2
+jobs_total{queue="mail",result="say \"hi\"\\\n"} 1
+# HELP workers Busy workers.
+# TYPE workers gauge
+workers 2
+# HELP job_seconds Time per job.
+# TYPE job_seconds histogram
+job_seconds_bucket{queue="mail",le="0.1"} 1
+job_seconds_bucket{queue="mail",le="1"} 2
+job_seconds_bucket{queue="mail",le="+Inf"} 3
+job_seconds_sum{queue="mail"} 2.55
+job_seconds_count{queue="mail"} 3
+# HELP temperature Line one\nline two.
+# TYPE temperature gauge
+temperature 21.5
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	r.Histogram("size", "Sizes.", []float64{10}).With().Observe(10)
+
+	got := scrape(t, r)
+	for _, line := range []string{`size_bucket{le="10"} 1`, `size_bucket{le="+Inf"} 1`, "size_sum 10", "size_count 1"} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, got)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("jobs_total", "Jobs run.")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic registering the same name twice")
+		}
+	}()
+	r.Gauge("jobs_total", "Jobs run.")
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import "database/sql"
+
+// DBStats registers the connection pool statistics of db, read on every scrape
+func DBStats(r *Registry, db *sql.DB) {
+	stat := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+
+	r.GaugeFunc("sql_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.GaugeFunc("sql_db_open_connections", "Established connections, in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.GaugeFunc("sql_db_in_use_connections", "Connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.GaugeFunc("sql_db_idle_connections", "Idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.CounterFunc("sql_db_wait_count_total", "Connections waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.CounterFunc("sql_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.CounterFunc("sql_db_max_idle_closed_total", "Connections closed due to the idle connection limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.CounterFunc("sql_db_max_idle_time_closed_total", "Connections closed due to the idle time limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	r.CounterFunc("sql_db_max_lifetime_closed_total", "Connections closed due to the lifetime limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
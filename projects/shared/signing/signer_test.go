@@ -0,0 +1,48 @@
+package signing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	now := time.Now()
+	q := s.Sign(http.MethodPost, "/images/1/a.png", now.Add(time.Minute), 1024)
+
+	max, err := s.Verify(http.MethodPost, "/images/1/a.png", q, now)
+	if err != nil || max != 1024 {
+		t.Fatalf("expected valid signature with max 1024, got %d %v", max, err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		at     time.Time
+		want   error
+	}{
+		{"other path", http.MethodPost, "/images/1/b.png", now, ErrInvalidSignature},
+		{"other method", http.MethodDelete, "/images/1/a.png", now, ErrInvalidSignature},
+		{"expired", http.MethodPost, "/images/1/a.png", now.Add(2 * time.Minute), ErrExpired},
+	}
+	for _, tt := range tests {
+		if _, err := s.Verify(tt.method, tt.path, q, tt.at); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// raising the size limit breaks the signature
+	q.Set(ParamMaxSize, "999999")
+	if _, err := s.Verify(http.MethodPost, "/images/1/a.png", q, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered max_size to fail, got %v", err)
+	}
+
+	// signed for GET also allows HEAD
+	q = s.Sign(http.MethodGet, "/images/1/a.png", now.Add(time.Minute), 0)
+	if _, err := s.Verify(http.MethodHead, "/images/1/a.png", q, now); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+)
+
+// Exporters are the values of Config.Exporter
+var Exporters = []string{"none", "stdout", "file", "otlp"}
+
+// Config chooses where a service sends its spans, a service keeps it under the
+// tracing key of its config
+type Config struct {
+	Exporter string `yaml:"exporter" env:"EXPORTER" default:"none" usage:"where spans are sent: none, stdout, file or otlp, none still passes traceparent on"`
+	// the file exporter appends a JSON line per span
+	Path string `yaml:"path" env:"PATH" usage:"file spans are appended to with the file exporter"`
+	// the otlp exporter posts to Endpoint/v1/traces
+	Endpoint string        `yaml:"endpoint" env:"ENDPOINT" default:"http://localhost:4318" usage:"OTLP/HTTP collector spans are sent to with the otlp exporter"`
+	Timeout  time.Duration `yaml:"timeout" env:"TIMEOUT" default:"10s" usage:"max time to send a batch of spans to the collector"`
+}
+
+// Validate checks the settings and returns all the problems found, keys are
+// given under tracing
+func (c Config) Validate() error {
+	var errs []error
+
+	if !slices.Contains(Exporters, c.Exporter) {
+		errs = append(errs, fmt.Errorf("tracing.exporter: must be one of %v, got %q", Exporters, c.Exporter))
+	}
+	if c.Exporter == "file" && c.Path == "" {
+		errs = append(errs, errors.New("tracing.path: must be set for the file exporter"))
+	}
+	if c.Exporter == "otlp" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("tracing.endpoint: %q is not an http or https URL", c.Endpoint))
+		}
+		if c.Timeout <= 0 {
+			errs = append(errs, errors.New("tracing.timeout: must be greater than 0"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// New creates the tracer of service with the exporter cfg chooses, cfg must be
+// valid. onError is called when spans can't be exported, it may be nil
+func New(cfg Config, service string, onError func(error)) (*Tracer, error) {
+	var exp Exporter
+	switch cfg.Exporter {
+	case "stdout":
+		exp = NewJSONExporter(os.Stdout)
+	case "file":
+		fe, err := OpenJSONFile(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		exp = fe
+	case "otlp":
+		exp = NewOTLPExporter(cfg.Endpoint, cfg.Timeout)
+	}
+
+	return NewTracer(service, exp, onError), nil
+}
@@ -0,0 +1,176 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// W3C Trace Context headers, see https://www.w3.org/TR/trace-context/
+const (
+	TraceparentHeader = "Traceparent"
+	TracestateHeader  = "Tracestate"
+)
+
+// TraceID identifies a trace, shared by every span of it
+type TraceID [16]byte
+
+// IsValid reports whether the id is not all zeros
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// IsValid reports whether the id is not all zeros
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span which is propagated to other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string // vendor specific, passed on unchanged
+	Remote     bool   // extracted from a request rather than started here
+}
+
+// IsValid reports whether the trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the traceparent header value, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrInvalidTraceparent is returned for a traceparent header which can't be used
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are read
+// as far as the fields 00 defines, as the specification asks
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+
+	version, ok := decodeHex(s[0:2], 1)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return sc, fmt.Errorf("%w: unsupported version in %q", ErrInvalidTraceparent, s)
+	}
+
+	tid, ok := decodeHex(s[3:35], 16)
+	if !ok {
+		return sc, fmt.Errorf("%w: trace id in %q", ErrInvalidTraceparent, s)
+	}
+	sid, ok := decodeHex(s[36:52], 8)
+	if !ok {
+		return sc, fmt.Errorf("%w: parent id in %q", ErrInvalidTraceparent, s)
+	}
+	flags, ok := decodeHex(s[53:55], 1)
+	if !ok {
+		return sc, fmt.Errorf("%w: flags in %q", ErrInvalidTraceparent, s)
+	}
+
+	copy(sc.TraceID[:], tid)
+	copy(sc.SpanID[:], sid)
+	sc.Sampled = flags[0]&1 == 1
+	sc.Remote = true
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: all zero id in %q", ErrInvalidTraceparent, s)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes n bytes of lower case hex, upper case is invalid in traceparent
+func decodeHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// Extract returns ctx carrying the trace context of the request headers h, or
+// ctx unchanged when they carry none or an invalid one
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+
+	// tracestate is only meaningful with a valid traceparent
+	sc.TraceState = strings.Join(h.Values(TracestateHeader), ",")
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject sets the traceparent and tracestate headers from the span in ctx, or
+// from the remote trace context when no span was started
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFrom(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// SpanFrom returns the span started in ctx, nil when there is none
+func SpanFrom(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContextFrom returns the context of the span in ctx, or the remote one
+// extracted into it
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if s := SpanFrom(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		rand.Read(s[:])
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere they can be looked at
+type Exporter interface {
+	// ExportSpans sends a batch of spans, it is never called concurrently
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	// Shutdown releases the exporter, no spans are exported after it
+	Shutdown(ctx context.Context) error
+}
+
+// DroppedError reports spans dropped because the export queue was full
+type DroppedError struct {
+	Count int64
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("dropped %d spans, the export queue is full", e.Count)
+}
+
+// JSONExporter writes each span as a line of JSON, to stdout or a file
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer // closed on Shutdown, nil for stdout
+}
+
+// NewJSONExporter writes spans to w
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// OpenJSONFile appends spans to the file at path, creating it when needed
+func OpenJSONFile(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open span file: %w", err)
+	}
+	return &JSONExporter{w: f, c: f}, nil
+}
+
+// jsonSpan is the line written for a span
+type jsonSpan struct {
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	TraceState    string         `json:"trace_state,omitempty"`
+	Service       string         `json:"service"`
+	Name          string         `json:"name"`
+	Kind          string         `json:"kind"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	DurationMS    float64        `json:"duration_ms"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Status        string         `json:"status,omitempty"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// ExportSpans writes the spans
+func (e *JSONExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:       s.TraceID.String(),
+			SpanID:        s.SpanID.String(),
+			TraceState:    s.TraceState,
+			Service:       s.Service,
+			Name:          s.Name,
+			Kind:          s.Kind.String(),
+			Start:         s.Start.UTC(),
+			End:           s.End.UTC(),
+			DurationMS:    float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			StatusMessage: s.StatusMessage,
+		}
+		if s.ParentSpanID.IsValid() {
+			js.ParentSpanID = s.ParentSpanID.String()
+		}
+		switch s.Status {
+		case StatusOK:
+			js.Status = "ok"
+		case StatusError:
+			js.Status = "error"
+		}
+		if len(s.Attrs) > 0 {
+			js.Attributes = make(map[string]any, len(s.Attrs))
+			for _, a := range s.Attrs {
+				js.Attributes[a.Key] = a.Value
+			}
+		}
+
+		err := enc.Encode(js)
+		if err != nil {
+			return fmt.Errorf("unable to write span: %w", err)
+		}
+	}
+	return nil
+}
+
+// Shutdown closes the file spans are written to
+func (e *JSONExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.c == nil {
+		return nil
+	}
+	err := e.c.Close()
+	e.c = nil
+	return err
+}
@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"net/http"
+
+	"shared/internal/httpx"
+)
+
+// Middleware starts a server span for each request served by next, continuing
+// the trace of the request's traceparent header when it has one. The span is
+// named after the method and route template, e.g. GET /product/{id}.
+//
+// Add it to a gorilla router with Use or wrap an http.ServeMux, as for the
+// metrics middleware
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := t.Start(ctx, r.Method, KindServer,
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+			String("client.address", r.RemoteAddr),
+		)
+		defer span.End()
+
+		sw := httpx.NewResponseWriter(rw)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(sw, r)
+
+		if route := httpx.Route(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(String("http.route", route))
+		}
+		span.SetAttributes(Int("http.response.status_code", sw.Status()))
+		if sw.Status() >= http.StatusInternalServerError {
+			span.SetStatus(StatusError, "")
+		}
+	})
+}
+
+// Transport starts a client span for each request sent with Base, a child of
+// the span in the request's context, and passes the trace on in the request's
+// traceparent and tracestate headers
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Start(r.Context(), r.Method, KindClient,
+		String("http.request.method", r.Method),
+		String("url.full", r.URL.Redacted()),
+		String("server.address", r.URL.Host),
+	)
+	defer span.End()
+
+	// a RoundTripper must not change the request it was given
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(StatusError, "")
+	}
+	return resp, nil
+}
@@ -0,0 +1,196 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// collector is a stand-in for an OpenTelemetry collector receiving OTLP/HTTP JSON
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+}
+
+func (c *collector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(rw, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write([]byte("{}"))
+}
+
+// spans returns the spans received by service name
+func (c *collector) spans() map[string][]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	got := map[string][]otlpSpan{}
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			service := *rs.Resource.Attributes[0].Value.StringValue
+			for _, ss := range rs.ScopeSpans {
+				got[service] = append(got[service], ss.Spans...)
+			}
+		}
+	}
+	return got
+}
+
+func TestPropagationToCollector(t *testing.T) {
+	col := &collector{}
+	cs := httptest.NewServer(col)
+	defer cs.Close()
+
+	// the downstream service, like file-server
+	down := NewTracer("file-server", NewOTLPExporter(cs.URL, time.Second), func(err error) { t.Error(err) })
+	dm := mux.NewRouter()
+	dm.HandleFunc("/images/{id:[0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "storage.list", KindInternal)
+		span.End()
+		rw.Write([]byte("[]"))
+	})
+	dm.Use(down.Middleware)
+	ds := httptest.NewServer(dm)
+	defer ds.Close()
+
+	// the service called by the browser, like product-api
+	up := NewTracer("product-api", NewOTLPExporter(cs.URL, time.Second), func(err error) { t.Error(err) })
+	client := &http.Client{Transport: &Transport{}}
+	um := mux.NewRouter()
+	um.HandleFunc("/product/{id:[0-9]+}/images", func(rw http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, ds.URL+"/images/1", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		io.Copy(rw, resp.Body)
+		resp.Body.Close()
+	})
+	um.Use(up.Middleware)
+	us := httptest.NewServer(um)
+	defer us.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, us.URL+"/product/1/images", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := up.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := down.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := col.spans()
+	find := func(service, name string) otlpSpan {
+		t.Helper()
+		for _, s := range got[service] {
+			if s.Name == name {
+				return s
+			}
+		}
+		t.Fatalf("expected span %q from %s, got %+v", name, service, got)
+		return otlpSpan{}
+	}
+
+	server := find("product-api", "GET /product/{id}/images")
+	call := find("product-api", "GET")
+	downstream := find("file-server", "GET /images/{id}")
+	storage := find("file-server", "storage.list")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, s := range []otlpSpan{server, call, downstream, storage} {
+		if s.TraceID != traceID {
+			t.Errorf("expected every span in trace %s, got %+v", traceID, s)
+		}
+	}
+	if server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != KindServer {
+		t.Errorf("expected the server span under the browser's span, got %+v", server)
+	}
+	if call.ParentSpanID != server.SpanID || call.Kind != KindClient {
+		t.Errorf("expected the client span under the server span, got %+v", call)
+	}
+	if downstream.ParentSpanID != call.SpanID {
+		t.Errorf("expected file-server's span under product-api's client span, got %+v", downstream)
+	}
+	if storage.ParentSpanID != downstream.SpanID {
+		t.Errorf("expected the storage span under file-server's span, got %+v", storage)
+	}
+
+	attrs := map[string]otlpValue{}
+	for _, a := range server.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if v := attrs["http.route"]; v.StringValue == nil || *v.StringValue != "/product/{id}/images" {
+		t.Errorf("expected the route template attribute, got %+v", server.Attributes)
+	}
+	if v := attrs["http.response.status_code"]; v.IntValue == nil || *v.IntValue != "200" {
+		t.Errorf("expected the status code attribute, got %+v", server.Attributes)
+	}
+	if server.StartTimeUnixNano == "" || server.EndTimeUnixNano < server.StartTimeUnixNano {
+		t.Errorf("unexpected times %s %s", server.StartTimeUnixNano, server.EndTimeUnixNano)
+	}
+}
+
+func TestMiddlewareServerError(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer("test", rec, nil)
+
+	sm := http.NewServeMux()
+	sm.HandleFunc("GET /fail", func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "boom", http.StatusInternalServerError)
+	})
+	h := tr.Middleware(sm)
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	tr.Shutdown(context.Background())
+
+	s := rec.byName(t, "GET /fail")
+	if s.Status != StatusError || s.ParentSpanID.IsValid() {
+		t.Fatalf("expected a failed root span, got %+v", s)
+	}
+}
+
+func TestOTLPExporterRejected(t *testing.T) {
+	cs := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer cs.Close()
+
+	err := NewOTLPExporter(cs.URL, time.Second).ExportSpans(context.Background(), []SpanData{{Name: "x"}})
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("expected the collector's error, got %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+// Package trace records spans and propagates W3C Trace Context between services.
+//
+// A Tracer starts the span of each request in its middleware, taking the trace
+// id and parent from the traceparent header. Code serving the request starts
+// child spans with Start, and Transport passes the trace on to the services it
+// calls. Finished spans are batched and sent to an Exporter
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the role of a span, the values are the ones OTLP uses
+type Kind int
+
+const (
+	KindInternal Kind = 1 // work within the service
+	KindServer   Kind = 2 // serving a request
+	KindClient   Kind = 3 // calling another service or the database
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// StatusCode reports whether the work of a span failed, the values are the ones OTLP uses
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attr is a key and value describing a span, the value is a string, int64,
+// float64 or bool
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, v string) Attr { return Attr{Key: key, Value: v} }
+
+// Int returns an integer attribute
+func Int(key string, v int) Attr { return Attr{Key: key, Value: int64(v)} }
+
+// Int64 returns an integer attribute
+func Int64(key string, v int64) Attr { return Attr{Key: key, Value: v} }
+
+// Float64 returns a floating point attribute
+func Float64(key string, v float64) Attr { return Attr{Key: key, Value: v} }
+
+// Bool returns a boolean attribute
+func Bool(key string, v bool) Attr { return Attr{Key: key, Value: v} }
+
+// SpanData is a finished span as given to an Exporter
+type SpanData struct {
+	Service       string
+	Name          string
+	Kind          Kind
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID // zero for the root span of a trace
+	TraceState    string
+	Start         time.Time
+	End           time.Time
+	Attrs         []Attr
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span is an operation being timed. The methods of a nil Span do nothing, so
+// code can trace without checking whether tracing is enabled
+type Span struct {
+	t      *Tracer
+	sc     SpanContext
+	parent SpanID
+	kind   Kind
+	start  time.Time
+
+	mu     sync.Mutex
+	name   string
+	attrs  []Attr
+	status StatusCode
+	msg    string
+	ended  bool
+}
+
+// Context returns the span's trace context
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName replaces the name given when the span started
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name = name
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetStatus sets whether the span's work failed, msg describes the error
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = code
+	s.msg = msg
+}
+
+// RecordError marks the span as failed with err, a nil err is ignored
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and queues it for export, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	d := SpanData{
+		Service:       s.t.service,
+		Name:          s.name,
+		Kind:          s.kind,
+		TraceID:       s.sc.TraceID,
+		SpanID:        s.sc.SpanID,
+		ParentSpanID:  s.parent,
+		TraceState:    s.sc.TraceState,
+		Start:         s.start,
+		End:           end,
+		Attrs:         s.attrs,
+		Status:        s.status,
+		StatusMessage: s.msg,
+	}
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.t.queue(d)
+	}
+}
+
+// Tracer starts spans for a service and exports them in batches
+type Tracer struct {
+	service string
+	exp     Exporter
+	onError func(error)
+
+	spans   chan SpanData
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped atomic.Bool
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+)
+
+// NewTracer creates a tracer for service sending spans to exp. With a nil exp
+// the trace context is still propagated but no spans are recorded. onError is
+// called when a batch can't be exported, it may be nil
+func NewTracer(service string, exp Exporter, onError func(error)) *Tracer {
+	t := &Tracer{service: service, exp: exp, onError: onError}
+	if exp == nil {
+		return t
+	}
+
+	t.spans = make(chan SpanData, queueSize)
+	t.flush = make(chan chan struct{})
+	t.done = make(chan struct{})
+	go t.run()
+
+	return t
+}
+
+// Start starts a span as a child of the span or remote trace context in ctx,
+// or as the root of a new trace when there is neither. End the span when the
+// work is done
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	parent := SpanContextFrom(ctx)
+
+	s := &Span{
+		t:     t,
+		kind:  kind,
+		start: time.Now(),
+		name:  name,
+		attrs: attrs,
+	}
+	if parent.IsValid() {
+		s.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, TraceState: parent.TraceState}
+		s.parent = parent.SpanID
+	} else {
+		s.sc = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+	s.sc.SpanID = newSpanID()
+
+	if t.exp == nil {
+		// nothing is recorded but the span id is still passed on
+		s.sc.Sampled = parent.Sampled
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start starts a child of the span in ctx using the same tracer. It returns a
+// nil Span, which does nothing, when no span was started in ctx
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	p := SpanFrom(ctx)
+	if p == nil {
+		return ctx, nil
+	}
+	return p.t.Start(ctx, name, kind, attrs...)
+}
+
+// queue hands a finished span to the export loop, dropping it when the queue is full
+func (t *Tracer) queue(d SpanData) {
+	if t.exp == nil || t.stopped.Load() {
+		return
+	}
+
+	select {
+	case t.spans <- d:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports the queued spans when a batch is full, on every interval and when flushed
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	tick := time.NewTicker(batchInterval)
+	defer tick.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if n := t.dropped.Swap(0); n > 0 && t.onError != nil {
+			t.onError(&DroppedError{Count: n})
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		err := t.exp.ExportSpans(context.Background(), batch)
+		if err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case d := <-t.spans:
+			batch = append(batch, d)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-tick.C:
+			export()
+		case ack := <-t.flush:
+			// take what is already queued before exporting
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			export()
+			if ack == nil {
+				return
+			}
+			close(ack)
+		}
+	}
+}
+
+// Flush exports the spans ended so far
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t.exp == nil || t.stopped.Load() {
+		return nil
+	}
+
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the spans ended so far and shuts the exporter down, spans
+// ending later are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exp == nil {
+		return nil
+	}
+
+	var err error
+	t.once.Do(func() {
+		t.stopped.Store(true)
+
+		select {
+		case t.flush <- nil:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+
+		err = t.exp.Shutdown(ctx)
+	})
+	return err
+}
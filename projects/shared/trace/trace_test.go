@@ -0,0 +1,246 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Exporter keeping the spans in memory
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) ExportSpans(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recorder) Shutdown(ctx context.Context) error { return nil }
+
+func (r *recorder) byName(t *testing.T, name string) SpanData {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("expected a span named %q in %+v", name, r.spans)
+	return SpanData{}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tc.value)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidTraceparent) {
+					t.Fatalf("expected ErrInvalidTraceparent, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || sc.Sampled != tc.sampled || !sc.Remote {
+				t.Fatalf("unexpected span context %+v", sc)
+			}
+		})
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	in := http.Header{}
+	in.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Add(TracestateHeader, "vendor=a")
+	in.Add(TracestateHeader, "other=b")
+
+	ctx := Extract(context.Background(), in)
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if got := out.Get(TraceparentHeader); got != in.Get(TraceparentHeader) {
+		t.Errorf("expected the remote traceparent to be passed on, got %q", got)
+	}
+	if got := out.Get(TracestateHeader); got != "vendor=a,other=b" {
+		t.Errorf("expected tracestate to be passed on, got %q", got)
+	}
+
+	// an invalid traceparent is ignored along with its tracestate
+	in.Set(TraceparentHeader, "garbage")
+	out = http.Header{}
+	Inject(Extract(context.Background(), in), out)
+	if len(out) != 0 {
+		t.Errorf("expected no headers, got %v", out)
+	}
+}
+
+func TestTracerStart(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer("test", rec, nil)
+
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set(TracestateHeader, "vendor=a")
+	ctx := Extract(context.Background(), h)
+
+	ctx, root := tr.Start(ctx, "GET /", KindServer)
+	_, child := Start(ctx, "SELECT products", KindClient, String("db.system", "postgresql"))
+	child.RecordError(errors.New("connection refused"))
+	child.End()
+	root.End()
+	root.End()
+
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(rec.spans))
+	}
+	r := rec.byName(t, "GET /")
+	c := rec.byName(t, "SELECT products")
+
+	if r.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || r.ParentSpanID.String() != "00f067aa0ba902b7" || r.TraceState != "vendor=a" {
+		t.Errorf("expected the root to continue the remote trace, got %+v", r)
+	}
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || c.SpanID == r.SpanID {
+		t.Errorf("expected the child under the root, got %+v", c)
+	}
+	if c.Status != StatusError || c.StatusMessage != "connection refused" || c.Kind != KindClient || c.Service != "test" {
+		t.Errorf("unexpected child %+v", c)
+	}
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer("test", rec, nil)
+
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, span := tr.Start(Extract(context.Background(), h), "GET /", KindServer)
+	span.End()
+	tr.Shutdown(context.Background())
+
+	if len(rec.spans) != 0 {
+		t.Fatalf("expected the caller's decision not to sample to be kept, got %+v", rec.spans)
+	}
+
+	// the trace is still passed on with a new parent
+	out := http.Header{}
+	Inject(ctx, out)
+	if got := out.Get(TraceparentHeader); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(got, "-00") || strings.Contains(got, "00f067aa0ba902b7") {
+		t.Fatalf("unexpected traceparent %q", got)
+	}
+}
+
+func TestStartWithoutSpan(t *testing.T) {
+	ctx, span := Start(context.Background(), "orphan", KindInternal)
+	if span != nil || SpanFrom(ctx) != nil {
+		t.Fatal("expected no span without a parent span")
+	}
+
+	// a nil span can be used as any other
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("failed"))
+	span.End()
+}
+
+func TestFlush(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer("test", rec, nil)
+	defer tr.Shutdown(context.Background())
+
+	_, span := tr.Start(context.Background(), "work", KindInternal)
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tr.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rec.byName(t, "work")
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTracer("file-server", NewJSONExporter(&buf), nil)
+
+	_, span := tr.Start(context.Background(), "storage.save", KindInternal, String("file.path", "1/a.png"), Int64("file.size", 30))
+	span.End()
+	tr.Shutdown(context.Background())
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %s", buf.String(), err)
+	}
+	if got["service"] != "file-server" || got["name"] != "storage.save" || got["kind"] != "internal" || len(got["trace_id"].(string)) != 32 {
+		t.Errorf("unexpected span %v", got)
+	}
+	if _, ok := got["parent_span_id"]; ok {
+		t.Errorf("expected no parent for a root span, got %v", got)
+	}
+	attrs := got["attributes"].(map[string]any)
+	if attrs["file.path"] != "1/a.png" || attrs["file.size"] != float64(30) {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		{Exporter: "none"},
+		{Exporter: "stdout"},
+		{Exporter: "file", Path: "/tmp/spans.json"},
+		{Exporter: "otlp", Endpoint: "http://collector:4318", Timeout: time.Second},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %s", c, err)
+		}
+	}
+
+	invalid := map[string]Config{
+		"tracing.exporter": {Exporter: "jaeger"},
+		"tracing.path":     {Exporter: "file"},
+		"tracing.endpoint": {Exporter: "otlp", Endpoint: "collector:4318", Timeout: time.Second},
+		"tracing.timeout":  {Exporter: "otlp", Endpoint: "http://collector:4318"},
+	}
+	for key, c := range invalid {
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected a %s error for %+v, got %v", key, c, err)
+		}
+	}
+}
@@ -1,3 +1,9 @@
 module web-server
 
 go 1.24.4
+
+require shared v0.0.0
+
+require github.com/gorilla/mux v1.8.1 // indirect
+
+replace shared => ../shared
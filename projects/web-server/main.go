@@ -3,17 +3,19 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
-	"web-server/handlers"
+	"shared/lifecycle"
+	"shared/metrics"
 	"time"
+	"web-server/handlers"
 )
 
 func main() {
 	// Create a logger that writes to stdout with a prefix and timestamp
 	l := log.New(os.Stdout, "hello-api ", log.LstdFlags)
-	
+
 	// Initialize handler instances with the logger
 	hh := handlers.NewHello(l)
 	gh := handlers.NewGoodbye(l)
@@ -23,47 +25,30 @@ func main() {
 	// For example, hh.ServeHTTP will be called for requests to "/"
 	// and gh.ServeHTTP will be called for requests to "/goodbye"
 	sm := http.NewServeMux()
-	sm.Handle("/", hh)          // Maps "/" to Hello handler
-	sm.Handle("/goodbye", gh)   // Maps "/goodbye" to Goodbye handler
+	sm.Handle("/", hh)        // Maps "/" to Hello handler
+	sm.Handle("/goodbye", gh) // Maps "/goodbye" to Goodbye handler
+
+	// Request counts and latencies per route, served to Prometheus on /metrics
+	// The middleware wraps the whole router so it can label requests with the matched pattern
+	mr := metrics.NewRegistry()
+	sm.Handle("/metrics", mr)
+	hm := metrics.NewHTTP(mr)
 
 	// Define the custom HTTP server configuration
 	s := &http.Server{
 		Addr:         ":9080",           // Server will listen on port 9080
-		Handler:      sm,                // Use our custom router
+		Handler:      hm.Middleware(sm), // Use our custom router, wrapped in the metrics middleware
 		IdleTimeout:  120 * time.Second, // Max idle time before disconnect
 		ReadTimeout:  1 * time.Second,   // Max time to read a request
 		WriteTimeout: 1 * time.Second,   // Max time to write a response
 	}
 
-	// Start the HTTP server in a new goroutine
-	// Goroutine is a lightweight thread in Go — it allows the server to run in the background
-	// so the main thread can wait for OS signals (like Ctrl+C)
-	go func() {
-		err := s.ListenAndServe()
-		if err != nil {
-			l.Fatal("Error starting server: ", err)
-		}
-	}()
-
-	// Create a channel to receive OS signals (like Interrupt or Kill)
-	sigChain := make(chan os.Signal)
-
-	// signal.Notify will send OS interrupt/kill signals into sigChain
-	// This is how we detect Ctrl+C or program termination
-	signal.Notify(sigChain, os.Interrupt)
-	signal.Notify(sigChain, os.Kill)
-
-	// Wait here until we receive a shutdown signal
-	// This blocks the main thread until a signal is received
-	sig := <-sigChain
-	l.Println("Received terminate, Graceful shutdown", sig)
-
-	// Create a context with timeout of 30 seconds to allow graceful shutdown
-	// This gives running requests a chance to complete before server exits
-	tc, _ := context.WithTimeout(context.Background(), 30*time.Second)
-
-	// Shutdown the server gracefully using the context timeout
-	s.Shutdown(tc)
-
-	log.Println("Server stopped gracefully")
+	// Serve until Ctrl+C (SIGINT) or SIGTERM, then give running requests up to 30
+	// seconds to complete before the server exits. Its messages are written through
+	// our logger, the exit code is 1 when the server fails to start or to stop
+	sl := slog.New(slog.NewTextHandler(l.Writer(), nil))
+	code := lifecycle.New(s, sl).
+		WithTimeout(30 * time.Second).
+		Run(context.Background())
+	os.Exit(code)
 }